@@ -0,0 +1,6 @@
+package audit
+
+type Service interface {
+	// Record appends an action of actor, details is stored as JSON
+	Record(actor, action string, details interface{})
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tradingview-binance-webhook/audit"
+	"tradingview-binance-webhook/models"
+)
+
+// Files are audit-000001.log, audit-000002.log... a rotated file is never
+// written again and the next one continues the chain
+const (
+	filePrefix  = "audit-"
+	fileSuffix  = ".log"
+	maxLineSize = 1024 * 1024
+)
+
+type auditService struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	file     int
+	size     int64
+	seq      uint64
+	lastHash string
+}
+
+// NewAuditService appends to the latest audit file in dir and rotates to a
+// new file once it grows over maxSize bytes, 0 never rotates
+func NewAuditService(dir string, maxSize int64) (audit.Service, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	a := &auditService{
+		dir:     dir,
+		maxSize: maxSize,
+		file:    1,
+	}
+
+	files, err := auditFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last := files[len(files)-1]
+		entries, err := readEntries(filepath.Join(dir, last.name))
+		if err != nil {
+			return nil, err
+		}
+		a.file = last.number
+		if info, err := os.Stat(filepath.Join(dir, last.name)); err == nil {
+			a.size = info.Size()
+		}
+		if len(entries) > 0 {
+			head := entries[len(entries)-1]
+			a.seq, a.lastHash = head.Seq, head.Hash
+		} else if len(files) > 1 {
+			// The latest file is empty, continue from the one before
+			previous, err := readEntries(filepath.Join(dir, files[len(files)-2].name))
+			if err != nil {
+				return nil, err
+			}
+			if len(previous) > 0 {
+				head := previous[len(previous)-1]
+				a.seq, a.lastHash = head.Seq, head.Hash
+			}
+		}
+	}
+
+	return a, nil
+}
+
+func (a *auditService) Record(actor, action string, details interface{}) {
+	var raw json.RawMessage
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			log.Println("Audit: ", err)
+			return
+		}
+		raw = data
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry := models.AuditEntry{
+		Seq:      a.seq + 1,
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		Action:   action,
+		Details:  raw,
+		PrevHash: a.lastHash,
+	}
+	entry.Hash = EntryHash(entry)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Audit: ", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		a.file++
+		a.size = 0
+	}
+
+	f, err := os.OpenFile(filepath.Join(a.dir, fileName(a.file)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Println("Audit: ", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		log.Println("Audit: ", err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		log.Println("Audit: ", err)
+	}
+
+	a.size += int64(len(line))
+	a.seq, a.lastHash = entry.Seq, entry.Hash
+}
+
+// EntryHash returns the SHA-256 of every field of entry but Hash
+func EntryHash(entry models.AuditEntry) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%s\n%s\n%s\n%s",
+		entry.Seq,
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		entry.Actor,
+		entry.Action,
+		entry.Details,
+		entry.PrevHash,
+	)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify walks every audit file of dir in order and checks that the
+// sequence has no gap and that every hash and link of the chain is intact
+func Verify(dir string) (*models.AuditVerification, error) {
+	result := &models.AuditVerification{}
+	files, err := auditFiles(dir)
+	if err != nil {
+		return result, err
+	}
+	result.Files = len(files)
+	var seq uint64
+	var lastHash string
+	for i, f := range files {
+		if f.number != i+1 {
+			return result, fmt.Errorf("%s: missing audit file %s", f.name, fileName(i+1))
+		}
+
+		entries, err := readEntries(filepath.Join(dir, f.name))
+		if err != nil {
+			return result, fmt.Errorf("%s: %v", f.name, err)
+		}
+		for _, e := range entries {
+			if e.Seq != seq+1 {
+				return result, fmt.Errorf("%s: gap after seq %d, found seq %d", f.name, seq, e.Seq)
+			}
+			if e.PrevHash != lastHash {
+				return result, fmt.Errorf("%s: seq %d doesn't link to the previous entry", f.name, e.Seq)
+			}
+			if EntryHash(e) != e.Hash {
+				return result, fmt.Errorf("%s: seq %d was modified", f.name, e.Seq)
+			}
+			seq, lastHash = e.Seq, e.Hash
+			result.Entries++
+		}
+	}
+
+	result.HeadSeq, result.HeadHash = seq, lastHash
+	return result, nil
+}
+
+type auditFile struct {
+	name   string
+	number int
+}
+
+func fileName(number int) string {
+	return fmt.Sprintf("%s%06d%s", filePrefix, number, fileSuffix)
+}
+
+// auditFiles returns the audit files of dir sorted by number
+func auditFiles(dir string) ([]auditFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []auditFile
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		var number int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "%d", &number); err != nil {
+			continue
+		}
+		files = append(files, auditFile{name: name, number: number})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].number < files[j].number
+	})
+	return files, nil
+}
+
+// readEntries reads the entries of one file, any unreadable line is an error
+func readEntries(path string) ([]models.AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []models.AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		var e models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
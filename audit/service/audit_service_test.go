@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog records n entries in dir, rotating at maxSize bytes
+func writeLog(t *testing.T, dir string, n int, maxSize int64) {
+	t.Helper()
+	a, err := NewAuditService(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		a.Record("test", "alert", map[string]int{"i": i})
+	}
+}
+
+func readLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+func writeLines(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	data := append(bytes.Join(lines, []byte("\n")), '\n')
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	first := fileName(1)
+
+	tests := []struct {
+		name        string
+		entries     int
+		maxSize     int64
+		tamper      func(t *testing.T, dir string)
+		wantEntries uint64
+		wantErr     string
+	}{
+		{
+			name:        "empty",
+			wantEntries: 0,
+		},
+		{
+			name:        "intact chain across files",
+			entries:     10,
+			maxSize:     400,
+			wantEntries: 10,
+		},
+		{
+			name:    "modified entry",
+			entries: 3,
+			tamper: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, first)
+				lines := readLines(t, path)
+				lines[1] = bytes.Replace(lines[1], []byte(`"actor":"test"`), []byte(`"actor":"evil"`), 1)
+				writeLines(t, path, lines)
+			},
+			wantErr: "seq 2 was modified",
+		},
+		{
+			name:    "removed entry",
+			entries: 3,
+			tamper: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, first)
+				lines := readLines(t, path)
+				writeLines(t, path, append(lines[:1:1], lines[2:]...))
+			},
+			wantErr: "gap after seq 1",
+		},
+		{
+			name:    "reordered entries",
+			entries: 2,
+			tamper: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, first)
+				lines := readLines(t, path)
+				lines[0], lines[1] = lines[1], lines[0]
+				writeLines(t, path, lines)
+			},
+			wantErr: "gap after seq 0",
+		},
+		{
+			name:    "missing file",
+			entries: 10,
+			maxSize: 400,
+			tamper: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, first)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "missing audit file " + first,
+		},
+		{
+			name:    "unreadable line",
+			entries: 2,
+			tamper: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, first)
+				writeLines(t, path, append(readLines(t, path), []byte("{not json")))
+			},
+			wantErr: "line 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeLog(t, dir, tt.entries, tt.maxSize)
+			if tt.tamper != nil {
+				tt.tamper(t, dir)
+			}
+
+			result, err := Verify(dir)
+			if result == nil {
+				t.Fatal("Verify returned a nil result")
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify error = %v", err)
+			}
+			if result.Entries != tt.wantEntries || result.HeadSeq != tt.wantEntries {
+				t.Errorf("Verify = %d entries, head seq %d, want %d", result.Entries, result.HeadSeq, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestNewAuditServiceContinuesTheChain(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, 4, 400)
+	writeLog(t, dir, 3, 400)
+
+	result, err := Verify(dir)
+	if err != nil {
+		t.Fatalf("Verify error = %v", err)
+	}
+	if result.Entries != 7 || result.Files < 2 {
+		t.Errorf("Verify = %d entries in %d files, want 7 entries in several files", result.Entries, result.Files)
+	}
+}
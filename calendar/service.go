@@ -0,0 +1,10 @@
+package calendar
+
+import "time"
+
+type Service interface {
+	IsBlackout(t time.Time) (bool, string)
+	// IsFundingBlackout checks t against the funding timestamps of a symbol,
+	// Binance sets them per symbol so they come from its premium index
+	IsFundingBlackout(t, lastFunding, nextFunding time.Time) (bool, string)
+}
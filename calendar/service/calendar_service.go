@@ -0,0 +1,232 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"tradingview-binance-webhook/calendar"
+	"tradingview-binance-webhook/models"
+)
+
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday,
+	"MON": time.Monday,
+	"TUE": time.Tuesday,
+	"WED": time.Wednesday,
+	"THU": time.Thursday,
+	"FRI": time.Friday,
+	"SAT": time.Saturday,
+}
+
+type calendarService struct {
+	location        *time.Location
+	windows         []models.BlackoutWindow
+	fundingBlackout time.Duration
+	events          []models.BlackoutEvent
+}
+
+// NewCalendarService is the trading session and blackout calendar, fundingBlackout
+// blocks the minutes around each funding timestamp
+func NewCalendarService(location *time.Location, windows []models.BlackoutWindow, fundingBlackout time.Duration, events []models.BlackoutEvent) calendar.Service {
+	return &calendarService{
+		location:        location,
+		windows:         windows,
+		fundingBlackout: fundingBlackout,
+		events:          events,
+	}
+}
+
+func (s *calendarService) IsBlackout(t time.Time) (bool, string) {
+	for _, e := range s.events {
+		if !t.Before(e.Start) && t.Before(e.End) {
+			return true, fmt.Sprintf("%s until %s", e.Reason, e.End.In(s.location).Format("2006-01-02 15:04 MST"))
+		}
+	}
+
+	local := t.In(s.location)
+	year, month, day := local.Date()
+	offset := local.Sub(time.Date(year, month, day, 0, 0, 0, 0, s.location))
+	for _, w := range s.windows {
+		for _, d := range w.Weekdays {
+			if d == local.Weekday() && offset >= w.Start && offset < w.End {
+				return true, w.Reason
+			}
+		}
+	}
+
+	return false, ""
+}
+
+// IsFundingBlackout blocks the minutes after lastFunding and before
+// nextFunding, a zero time is skipped
+func (s *calendarService) IsFundingBlackout(t, lastFunding, nextFunding time.Time) (bool, string) {
+	if s.fundingBlackout <= 0 {
+		return false, ""
+	}
+	if !lastFunding.IsZero() && !t.Before(lastFunding) && t.Sub(lastFunding) < s.fundingBlackout {
+		return true, fmt.Sprintf("funding at %s", lastFunding.In(s.location).Format("15:04 MST"))
+	}
+	if !nextFunding.IsZero() && t.Before(nextFunding) && nextFunding.Sub(t) < s.fundingBlackout {
+		return true, fmt.Sprintf("funding at %s", nextFunding.In(s.location).Format("15:04 MST"))
+	}
+	return false, ""
+}
+
+// ParseWindows parses recurring windows such as "SAT 00:00-24:00,MON-FRI 13:25-13:35,* 23:55-24:00".
+// A window that ends before it starts crosses midnight, "FRI 22:00-02:00"
+// runs from Friday 22:00 to Saturday 02:00.
+func ParseWindows(s string) ([]models.BlackoutWindow, error) {
+	var windows []models.BlackoutWindow
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		fields := strings.Fields(v)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid blackout window: %s", v)
+		}
+
+		days, err := parseWeekdays(strings.ToUpper(fields[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		times := strings.SplitN(fields[1], "-", 2)
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid blackout window: %s", v)
+		}
+		start, err := parseClock(times[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(times[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if start == end {
+			return nil, fmt.Errorf("invalid blackout window, empty: %s", v)
+		}
+		if start < end {
+			windows = append(windows, models.BlackoutWindow{
+				Weekdays: days,
+				Start:    start,
+				End:      end,
+				Reason:   "session window " + v,
+			})
+			continue
+		}
+
+		// Split at midnight, the end falls on the next days
+		windows = append(windows, models.BlackoutWindow{
+			Weekdays: days,
+			Start:    start,
+			End:      24 * time.Hour,
+			Reason:   "session window " + v,
+		})
+		if end > 0 {
+			nextDays := make([]time.Weekday, len(days))
+			for i, d := range days {
+				nextDays[i] = (d + 1) % 7
+			}
+			windows = append(windows, models.BlackoutWindow{
+				Weekdays: nextDays,
+				Start:    0,
+				End:      end,
+				Reason:   "session window " + v,
+			})
+		}
+	}
+	return windows, nil
+}
+
+// WeekendWindows blocks Saturday and Sunday
+func WeekendWindows() []models.BlackoutWindow {
+	return []models.BlackoutWindow{{
+		Weekdays: []time.Weekday{time.Saturday, time.Sunday},
+		Start:    0,
+		End:      24 * time.Hour,
+		Reason:   "weekend",
+	}}
+}
+
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	if s == "*" {
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, nil
+	}
+
+	var days []time.Weekday
+	for _, v := range strings.Split(s, "|") {
+		r := strings.SplitN(v, "-", 2)
+		from, ok := weekdays[r[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday: %s", r[0])
+		}
+		to := from
+		if len(r) == 2 {
+			if to, ok = weekdays[r[1]]; !ok {
+				return nil, fmt.Errorf("invalid weekday: %s", r[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight, 24:00 is the end of the day
+func parseClock(s string) (time.Duration, error) {
+	var hour, min int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &min); err != nil || hour < 0 || hour > 24 || min < 0 || min > 59 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute, nil
+}
+
+// LoadEvents reads one-off blackout ranges from a JSON file, times without a
+// zone ("2006-01-02 15:04") are in location
+func LoadEvents(path string, location *time.Location) ([]models.BlackoutEvent, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []struct {
+		Start  string `json:"start"`
+		End    string `json:"end"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	events := make([]models.BlackoutEvent, 0, len(raw))
+	for _, v := range raw {
+		start, err := parseEventTime(v.Start, location)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseEventTime(v.End, location)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, models.BlackoutEvent{Start: start, End: end, Reason: v.Reason})
+	}
+	return events, nil
+}
+
+func parseEventTime(s string, location *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", s, location)
+}
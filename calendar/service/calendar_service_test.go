@@ -0,0 +1,140 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tradingview-binance-webhook/models"
+)
+
+func TestParseWindows(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []models.BlackoutWindow
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "weekday range",
+			input: "MON-FRI 13:25-13:35",
+			want: []models.BlackoutWindow{
+				{Weekdays: weekdays, Start: 13*time.Hour + 25*time.Minute, End: 13*time.Hour + 35*time.Minute, Reason: "session window MON-FRI 13:25-13:35"},
+			},
+		},
+		{
+			name:  "end of day",
+			input: "sat 00:00-24:00",
+			want: []models.BlackoutWindow{
+				{Weekdays: []time.Weekday{time.Saturday}, Start: 0, End: 24 * time.Hour, Reason: "session window sat 00:00-24:00"},
+			},
+		},
+		{
+			name:  "wrapping weekdays",
+			input: "SAT-MON 10:00-11:00",
+			want: []models.BlackoutWindow{
+				{Weekdays: []time.Weekday{time.Saturday, time.Sunday, time.Monday}, Start: 10 * time.Hour, End: 11 * time.Hour, Reason: "session window SAT-MON 10:00-11:00"},
+			},
+		},
+		{
+			name:  "crosses midnight",
+			input: "FRI|SAT 22:00-02:00",
+			want: []models.BlackoutWindow{
+				{Weekdays: []time.Weekday{time.Friday, time.Saturday}, Start: 22 * time.Hour, End: 24 * time.Hour, Reason: "session window FRI|SAT 22:00-02:00"},
+				{Weekdays: []time.Weekday{time.Saturday, time.Sunday}, Start: 0, End: 2 * time.Hour, Reason: "session window FRI|SAT 22:00-02:00"},
+			},
+		},
+		{
+			name:  "ends at midnight",
+			input: "* 23:55-00:00",
+			want: []models.BlackoutWindow{
+				{Weekdays: []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, Start: 23*time.Hour + 55*time.Minute, End: 24 * time.Hour, Reason: "session window * 23:55-00:00"},
+			},
+		},
+		{name: "empty window", input: "MON 10:00-10:00", wantErr: true},
+		{name: "missing time", input: "MON", wantErr: true},
+		{name: "invalid weekday", input: "MOX 10:00-11:00", wantErr: true},
+		{name: "invalid time", input: "MON 25:00-26:00", wantErr: true},
+		{name: "missing end", input: "MON 10:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWindows(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWindows(%q) error = %v, wantErr %t", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWindows(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBlackoutAcrossMidnight(t *testing.T) {
+	windows, err := ParseWindows("FRI 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewCalendarService(time.UTC, windows, 0, nil)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"friday before", time.Date(2026, 10, 16, 21, 59, 0, 0, time.UTC), false},
+		{"friday night", time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC), true},
+		{"saturday morning", time.Date(2026, 10, 17, 1, 59, 0, 0, time.UTC), true},
+		{"saturday after", time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC), false},
+		{"thursday night", time.Date(2026, 10, 15, 23, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := s.IsBlackout(tt.at); got != tt.want {
+				t.Errorf("IsBlackout(%s) = %t, want %t", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsFundingBlackout(t *testing.T) {
+	s := NewCalendarService(time.UTC, nil, 5*time.Minute, nil)
+	last := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	next := last.Add(4 * time.Hour)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		last time.Time
+		next time.Time
+		want bool
+	}{
+		{"just after funding", last.Add(2 * time.Minute), last, next, true},
+		{"after the window", last.Add(5 * time.Minute), last, next, false},
+		{"just before funding", next.Add(-3 * time.Minute), last, next, true},
+		{"4h interval, not 8h", last.Add(3*time.Hour + 58*time.Minute), last, next, true},
+		{"no funding times", last.Add(time.Minute), time.Time{}, time.Time{}, false},
+		{"before the window", next.Add(-6 * time.Minute), last, next, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := s.IsFundingBlackout(tt.at, tt.last, tt.next); got != tt.want {
+				t.Errorf("IsFundingBlackout(%s) = %t, want %t", tt.at, got, tt.want)
+			}
+		})
+	}
+
+	disabled := NewCalendarService(time.UTC, nil, 0, nil)
+	if got, _ := disabled.IsFundingBlackout(last, last, next); got {
+		t.Error("IsFundingBlackout with no blackout = true, want false")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+
+	_auditService "tradingview-binance-webhook/audit/service"
+	"tradingview-binance-webhook/export"
+	_exportService "tradingview-binance-webhook/export/service"
+	"tradingview-binance-webhook/journal"
+	_journalService "tradingview-binance-webhook/journal/service"
+)
+
+// runCommand runs a command line tool instead of the server, e.g.
+// app journal -symbol BTCUSDT -from 2022-08-01 -outcome rejected
+func runCommand(name string, args []string) error {
+	switch name {
+	case "journal":
+		return runJournal(args)
+	case "audit-verify":
+		return runAuditVerify()
+	case "export":
+		return runExport(args)
+	}
+	return fmt.Errorf("unknown command: %s", name)
+}
+
+// runJournal prints the journal entries matching the flags, one line each or as JSON
+func runJournal(args []string) error {
+	fs := flag.NewFlagSet("journal", flag.ExitOnError)
+	values := url.Values{}
+	for _, name := range []string{"id", "symbol", "strategy", "outcome", "type", "from", "to", "limit"} {
+		name := name
+		fs.Func(name, "filter by "+name, func(v string) error {
+			values.Set(name, v)
+			return nil
+		})
+	}
+	asJSON := fs.Bool("json", false, "print the entries as JSON lines")
+	fs.Parse(args)
+
+	filter, err := journal.ParseFilter(values, config.Location)
+	if err != nil {
+		return err
+	}
+
+	entries, err := _journalService.NewJournalService(config.JournalDir).Query(filter)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, e := range entries {
+		if *asJSON {
+			encoder.Encode(e)
+			continue
+		}
+		fields := []string{
+			e.Time.In(config.Location).Format("2006-01-02 15:04:05"),
+			e.CorrelationID,
+			e.Type,
+			e.Symbol,
+			e.Side,
+			e.Strategy,
+			e.Outcome,
+		}
+		if e.RealizedPnl != 0 {
+			fields = append(fields, fmt.Sprintf("pnl=%.4f", e.RealizedPnl))
+		}
+		if e.Reason != "" {
+			fields = append(fields, e.Reason)
+		}
+		fmt.Println(strings.Join(fields, " | "))
+	}
+	return nil
+}
+
+// runAuditVerify checks the whole audit chain and prints its head, keep the
+// head hash somewhere else to also detect a truncated log
+func runAuditVerify() error {
+	result, err := _auditService.Verify(config.AuditDir)
+	if err != nil {
+		return fmt.Errorf("audit log verification failed after %d entries: %v", result.Entries, err)
+	}
+	fmt.Printf("OK: %d files, %d entries, head seq %d, head hash %s\n", result.Files, result.Entries, result.HeadSeq, result.HeadHash)
+	return nil
+}
+
+// runExport writes the trades and income of a date range as CSV or JSON, e.g.
+// app export -from 2022-07-01 -to 2022-09-30 -view days -o /data/2022q3.csv
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	values := url.Values{}
+	for _, name := range []string{"from", "to", "symbol", "tz", "quote"} {
+		name := name
+		fs.Func(name, "export "+name, func(v string) error {
+			values.Set(name, v)
+			return nil
+		})
+	}
+	format := fs.String("format", export.FormatCSV, "csv or json")
+	view := fs.String("view", export.ViewTrades, "csv table, trades or days")
+	output := fs.String("o", "", "output file, stdout when empty")
+	fs.Parse(args)
+
+	request, err := export.ParseRequest(values, config.Location, config.ExportQuoteCurrency)
+	if err != nil {
+		return err
+	}
+
+	futuresClient := binance.NewFuturesClient(config.BinanceAPIKey, config.BinanceAPISecret)
+	result, err := _exportService.NewExportService(futuresClient).Export(request)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range result.Warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return export.Write(w, result, *format, *view)
+}
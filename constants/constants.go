@@ -4,4 +4,109 @@ const (
 	CodeSuccess      = 200
 	CodeError        = 400
 	CodeUnauthorized = 401
+	CodePaused       = 423
+)
+
+// Slippage guard actions
+const (
+	SlippageActionReject   = "reject"
+	SlippageActionDownsize = "downsize"
+	SlippageActionLimit    = "limit"
+)
+
+// Price sources used to compare with the alert price
+const (
+	PriceSourceMark = "mark"
+	PriceSourceBook = "book"
+)
+
+// Margin guard actions
+const (
+	MarginGuardActionReject = "reject"
+	MarginGuardActionShrink = "shrink"
+)
+
+// Liquidation guard modes
+const (
+	LiquidationGuardReject       = "reject"
+	LiquidationGuardAutoLeverage = "auto_leverage"
+)
+
+// TP/SL modes
+const (
+	TpSlModePercent = "percent"
+	TpSlModeATR     = "atr"
+)
+
+// Funding rate filter actions
+const (
+	FundingActionReject = "reject"
+	FundingActionShrink = "shrink"
+)
+
+// Trading states, entries_paused still manages the open positions, paused
+// stops every order the bot sends on its own: the circuit breaker flatten,
+// the reconciliation fixes and the TP/SL repairs
+const (
+	TradingStateRunning       = "running"
+	TradingStateEntriesPaused = "entries_paused"
+	TradingStatePaused        = "paused"
+)
+
+// Add-on modes, average adds to a losing position and pyramid to a winning one
+const (
+	AddOnModeAverage = "average"
+	AddOnModePyramid = "pyramid"
+	AddOnModeNone    = "none"
+)
+
+// State store backends
+const (
+	StateBackendBolt   = "bolt"
+	StateBackendMemory = "memory"
+)
+
+// Journal entry types and outcomes, fills use the order status as outcome
+const (
+	JournalTypeAlert    = "alert"
+	JournalTypeDecision = "decision"
+	JournalTypeOrder    = "order"
+	JournalTypeFill     = "fill"
+
+	JournalOutcomeAccepted = "accepted"
+	JournalOutcomeRejected = "rejected"
+	JournalOutcomePlaced   = "placed"
+	JournalOutcomeFailed   = "failed"
+)
+
+// Reconciliation policies
+const (
+	ReconcilePolicyReport = "report"
+	ReconcilePolicyFix    = "fix"
+)
+
+// Entry workflow steps, in order
+const (
+	WorkflowStepStarted      = "started"
+	WorkflowStepSetup        = "setup"
+	WorkflowStepEntryPending = "entry_pending"
+	WorkflowStepEntry        = "entry"
+	WorkflowStepTakeProfit   = "take_profit"
+	WorkflowStepStopLoss     = "stop_loss"
+)
+
+// Recovery of unfinished workflows, resume places the missing TP/SL and
+// compensates only when that fails, compensate always closes the position
+const (
+	WorkflowRecoveryResume     = "resume"
+	WorkflowRecoveryCompensate = "compensate"
+)
+
+// Audit log actions
+const (
+	AuditActionAlert        = "alert"
+	AuditActionOrder        = "order"
+	AuditActionAdmin        = "admin"
+	AuditActionConfig       = "config"
+	AuditActionTradingState = "trading_state"
 )
@@ -0,0 +1,172 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/utils"
+)
+
+// Formats and views of an export
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	ViewTrades = "trades"
+	ViewDays   = "days"
+)
+
+const (
+	// Binance keeps about 6 months of account trades and 3 months of income history
+	TradeHistoryMaxAge  = 180 * 24 * time.Hour
+	IncomeHistoryMaxAge = 90 * 24 * time.Hour
+	// The export is built in memory, a longer range is split into several exports
+	MaxRange = 92 * 24 * time.Hour
+)
+
+type Service interface {
+	Export(request models.ExportRequest) (*models.Export, error)
+}
+
+// ParseRequest reads an export request from the API parameters or the CLI
+// flags: from, to, symbol (comma separated), tz and quote. from is required,
+// to defaults to now and a date to includes the whole day. Dates are read in
+// tz, loc and quote are the defaults. from can't be older than the trade
+// history Binance keeps and the range is at most MaxRange.
+func ParseRequest(values url.Values, loc *time.Location, quote string) (models.ExportRequest, error) {
+	request := models.ExportRequest{
+		Location: loc,
+		Quote:    quote,
+		To:       time.Now(),
+	}
+
+	if v := values.Get("tz"); v != "" {
+		l, err := time.LoadLocation(v)
+		if err != nil {
+			return request, fmt.Errorf("invalid tz: %s", v)
+		}
+		request.Location = l
+	}
+	if v := values.Get("quote"); v != "" {
+		request.Quote = strings.ToUpper(v)
+	}
+	if v := values.Get("symbol"); v != "" {
+		for _, symbol := range strings.Split(v, ",") {
+			if symbol = strings.TrimSpace(symbol); symbol != "" {
+				request.Symbols = append(request.Symbols, strings.ToUpper(symbol))
+			}
+		}
+	}
+
+	v := values.Get("from")
+	if v == "" {
+		return request, fmt.Errorf("from is required")
+	}
+	from, _, err := utils.ParseTime(v, request.Location)
+	if err != nil {
+		return request, fmt.Errorf("invalid from: %s", v)
+	}
+	request.From = from
+
+	if v := values.Get("to"); v != "" {
+		t, isDate, err := utils.ParseTime(v, request.Location)
+		if err != nil {
+			return request, fmt.Errorf("invalid to: %s", v)
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		request.To = t
+	}
+	if !request.To.After(request.From) {
+		return request, fmt.Errorf("to must be after from")
+	}
+	if time.Since(request.From) > TradeHistoryMaxAge {
+		return request, fmt.Errorf("from must be within the last %d days, Binance doesn't keep older trades", int(TradeHistoryMaxAge.Hours()/24))
+	}
+	if request.To.Sub(request.From) > MaxRange {
+		return request, fmt.Errorf("range over %d days, split the export", int(MaxRange.Hours()/24))
+	}
+
+	return request, nil
+}
+
+// Write writes the export as JSON, trades and days together, or as the CSV
+// table of view
+func Write(w io.Writer, export *models.Export, format, view string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	case FormatCSV:
+		switch view {
+		case ViewTrades:
+			return writeTradesCSV(w, export)
+		case ViewDays:
+			return writeDaysCSV(w, export)
+		}
+		return fmt.Errorf("invalid view: %s", view)
+	}
+	return fmt.Errorf("invalid format: %s", format)
+}
+
+// formatAmount drops the float noise of the sums, Binance amounts have at most 8 decimals
+func formatAmount(f float64) string {
+	return strconv.FormatFloat(utils.ToFixed(f, 8), 'f', -1, 64)
+}
+
+func writeTradesCSV(w io.Writer, export *models.Export) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"time", "symbol", "trade_id", "order_id", "side", "position_side", "maker",
+		"price", "quantity", "quote_quantity",
+		"realized_pnl", "commission", "commission_asset", "commission_" + strings.ToLower(export.Quote), "net",
+	})
+	for _, t := range export.Trades {
+		cw.Write([]string{
+			t.Time.Format("2006-01-02 15:04:05"),
+			t.Symbol,
+			strconv.FormatInt(t.TradeID, 10),
+			strconv.FormatInt(t.OrderID, 10),
+			t.Side,
+			t.PositionSide,
+			strconv.FormatBool(t.Maker),
+			formatAmount(t.Price),
+			formatAmount(t.Quantity),
+			formatAmount(t.QuoteQuantity),
+			formatAmount(t.RealizedPnl),
+			formatAmount(t.Commission),
+			t.CommissionAsset,
+			formatAmount(t.CommissionQuote),
+			formatAmount(t.Net),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeDaysCSV(w io.Writer, export *models.Export) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "trades", "volume", "realized_pnl", "commission", "funding_fee", "other_income", "net"})
+	for _, d := range export.Days {
+		cw.Write([]string{
+			d.Date,
+			strconv.Itoa(d.Trades),
+			formatAmount(d.Volume),
+			formatAmount(d.RealizedPnl),
+			formatAmount(d.Commission),
+			formatAmount(d.FundingFee),
+			formatAmount(d.OtherIncome),
+			formatAmount(d.Net),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
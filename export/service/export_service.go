@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/export"
+	"tradingview-binance-webhook/models"
+)
+
+const (
+	tradeHistoryLimit  = 1000
+	incomeHistoryLimit = 1000
+	// The account trades are queried at most 7 days at a time
+	tradeWindow = 7 * 24 * time.Hour
+	// Pause between the requests, a long range of many symbols is hundreds of them
+	requestInterval = 200 * time.Millisecond
+)
+
+// Income types of the income history
+const (
+	incomeTypeRealizedPnl = "REALIZED_PNL"
+	incomeTypeCommission  = "COMMISSION"
+	incomeTypeFundingFee  = "FUNDING_FEE"
+	incomeTypeTransfer    = "TRANSFER"
+)
+
+// Margin assets of the USDⓈ-M symbols, the suffix of the symbol
+var marginAssets = []string{"USDT", "BUSD", "USDC"}
+
+type exportService struct {
+	client *futures.Client
+}
+
+func NewExportService(client *futures.Client) export.Service {
+	return &exportService{
+		client: client,
+	}
+}
+
+// exportRun holds the conversion rates fetched during one export
+type exportRun struct {
+	*exportService
+	request models.ExportRequest
+	rates   map[string]float64
+}
+
+// Export merges the account trades and the income history of the range. The
+// trades come from the account trade list of every symbol with commission
+// income in the range, the days sum the income history so funding fees and
+// other income are included. Amounts in another asset are converted to the
+// quote currency at the hourly open price of their futures market.
+func (e *exportService) Export(request models.ExportRequest) (*models.Export, error) {
+	if request.Location == nil {
+		request.Location = time.UTC
+	}
+	run := &exportRun{
+		exportService: e,
+		request:       request,
+		rates:         make(map[string]float64),
+	}
+
+	incomes, err := run.listIncome()
+	if err != nil {
+		return nil, fmt.Errorf("Export income history: %v", err)
+	}
+
+	symbols := request.Symbols
+	if len(symbols) == 0 {
+		seen := make(map[string]bool)
+		for _, income := range incomes {
+			if income.IncomeType != incomeTypeCommission && income.IncomeType != incomeTypeRealizedPnl {
+				continue
+			}
+			if income.Symbol != "" && !seen[income.Symbol] {
+				seen[income.Symbol] = true
+				symbols = append(symbols, income.Symbol)
+			}
+		}
+		sort.Strings(symbols)
+	}
+
+	result := &models.Export{
+		From:     request.From.In(request.Location),
+		To:       request.To.In(request.Location),
+		TimeZone: request.Location.String(),
+		Quote:    request.Quote,
+		Trades:   []models.ExportTrade{},
+		Days:     []models.ExportDay{},
+	}
+	if incomeStart := time.Now().Add(-export.IncomeHistoryMaxAge); request.From.Before(incomeStart) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("Binance keeps %d days of income history, the days before %s miss the realized PnL, commissions and funding fees",
+			int(export.IncomeHistoryMaxAge.Hours()/24), incomeStart.In(request.Location).Format("2006-01-02")))
+	}
+
+	for _, symbol := range symbols {
+		trades, err := run.listTrades(symbol)
+		if err != nil {
+			return nil, fmt.Errorf("Export %s trades: %v", symbol, err)
+		}
+		for _, trade := range trades {
+			t, err := run.exportTrade(trade)
+			if err != nil {
+				return nil, fmt.Errorf("Export %s trade %d: %v", symbol, trade.ID, err)
+			}
+			result.Trades = append(result.Trades, t)
+		}
+	}
+	sort.SliceStable(result.Trades, func(i, j int) bool {
+		return result.Trades[i].Time.Before(result.Trades[j].Time)
+	})
+
+	result.Days, err = run.days(result.Trades, incomes)
+	if err != nil {
+		return nil, fmt.Errorf("Export days: %v", err)
+	}
+
+	return result, nil
+}
+
+// listIncome pages through the income history of the range, restricted to
+// the requested symbols
+func (r *exportRun) listIncome() ([]*futures.IncomeHistory, error) {
+	symbols := make(map[string]bool)
+	for _, symbol := range r.request.Symbols {
+		symbols[symbol] = true
+	}
+
+	var result []*futures.IncomeHistory
+	seen := make(map[string]bool)
+	startTime, endTime := r.request.From.UnixMilli(), r.request.To.UnixMilli()-1
+	for {
+		incomes, err := r.client.NewGetIncomeHistoryService().
+			StartTime(startTime).
+			EndTime(endTime).
+			Limit(incomeHistoryLimit).
+			Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		time.Sleep(requestInterval)
+
+		var added int
+		for _, v := range incomes {
+			// Pages overlap on the boundary millisecond
+			key := fmt.Sprintf("%d:%s", v.TranID, v.IncomeType)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			added++
+			if len(symbols) > 0 && !symbols[v.Symbol] {
+				continue
+			}
+			result = append(result, v)
+		}
+
+		if len(incomes) < incomeHistoryLimit || added == 0 {
+			break
+		}
+		startTime = incomes[len(incomes)-1].Time
+	}
+
+	return result, nil
+}
+
+// listTrades returns the account trades of symbol in the range, 7 days per
+// window and by trade id when a window holds more than one page
+func (r *exportRun) listTrades(symbol string) ([]*futures.AccountTrade, error) {
+	var result []*futures.AccountTrade
+	end := r.request.To.UnixMilli() - 1
+
+	for start := r.request.From; start.UnixMilli() <= end; start = start.Add(tradeWindow) {
+		windowEnd := start.Add(tradeWindow).UnixMilli() - 1
+		if windowEnd > end {
+			windowEnd = end
+		}
+
+		trades, err := r.client.NewListAccountTradeService().
+			Symbol(symbol).
+			StartTime(start.UnixMilli()).
+			EndTime(windowEnd).
+			Limit(tradeHistoryLimit).
+			Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		time.Sleep(requestInterval)
+		result = append(result, trades...)
+
+		// fromId can't be combined with a time range, page until the window ends
+		for len(trades) == tradeHistoryLimit {
+			trades, err = r.client.NewListAccountTradeService().
+				Symbol(symbol).
+				FromID(trades[len(trades)-1].ID + 1).
+				Limit(tradeHistoryLimit).
+				Do(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			time.Sleep(requestInterval)
+
+			var page []*futures.AccountTrade
+			for _, t := range trades {
+				if t.Time <= windowEnd {
+					page = append(page, t)
+				}
+			}
+			result = append(result, page...)
+			if len(page) < len(trades) {
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (r *exportRun) exportTrade(trade *futures.AccountTrade) (models.ExportTrade, error) {
+	t := models.ExportTrade{
+		Time:            time.UnixMilli(trade.Time).In(r.request.Location),
+		Symbol:          trade.Symbol,
+		TradeID:         trade.ID,
+		OrderID:         trade.OrderID,
+		Side:            string(trade.Side),
+		PositionSide:    string(trade.PositionSide),
+		Maker:           trade.Maker,
+		CommissionAsset: trade.CommissionAsset,
+	}
+	t.Price, _ = strconv.ParseFloat(trade.Price, 64)
+	t.Quantity, _ = strconv.ParseFloat(trade.Quantity, 64)
+	t.Commission, _ = strconv.ParseFloat(trade.Commission, 64)
+	quoteQuantity, _ := strconv.ParseFloat(trade.QuoteQuantity, 64)
+	realizedPnl, _ := strconv.ParseFloat(trade.RealizedPnl, 64)
+
+	// The quote quantity and the PnL are in the margin asset of the symbol
+	rate, err := r.rate(marginAsset(trade.Symbol), trade.Time)
+	if err != nil {
+		return t, err
+	}
+	t.QuoteQuantity = quoteQuantity * rate
+	t.RealizedPnl = realizedPnl * rate
+
+	commissionRate, err := r.rate(trade.CommissionAsset, trade.Time)
+	if err != nil {
+		return t, err
+	}
+	t.CommissionQuote = t.Commission * commissionRate
+	t.Net = t.RealizedPnl - t.CommissionQuote
+
+	return t, nil
+}
+
+// days sums the trades and the income per day of the export time zone,
+// commissions are positive costs, funding fees and other income are signed
+func (r *exportRun) days(trades []models.ExportTrade, incomes []*futures.IncomeHistory) ([]models.ExportDay, error) {
+	byDate := make(map[string]*models.ExportDay)
+	day := func(t time.Time) *models.ExportDay {
+		date := t.In(r.request.Location).Format("2006-01-02")
+		if byDate[date] == nil {
+			byDate[date] = &models.ExportDay{Date: date}
+		}
+		return byDate[date]
+	}
+
+	for _, t := range trades {
+		d := day(t.Time)
+		d.Trades++
+		d.Volume += t.QuoteQuantity
+	}
+
+	for _, income := range incomes {
+		if income.IncomeType == incomeTypeTransfer {
+			continue
+		}
+		amount, err := strconv.ParseFloat(income.Income, 64)
+		if err != nil {
+			continue
+		}
+		rate, err := r.rate(income.Asset, income.Time)
+		if err != nil {
+			return nil, err
+		}
+		amount *= rate
+
+		d := day(time.UnixMilli(income.Time))
+		switch income.IncomeType {
+		case incomeTypeRealizedPnl:
+			d.RealizedPnl += amount
+		case incomeTypeCommission:
+			d.Commission -= amount
+		case incomeTypeFundingFee:
+			d.FundingFee += amount
+		default:
+			d.OtherIncome += amount
+		}
+	}
+
+	result := []models.ExportDay{}
+	for _, d := range byDate {
+		d.Net = d.RealizedPnl - d.Commission + d.FundingFee + d.OtherIncome
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date < result[j].Date
+	})
+	return result, nil
+}
+
+// rate converts one unit of asset to the quote currency at the open of the
+// hour of timestamp, from the ASSET+QUOTE market or the inverse of QUOTE+ASSET
+func (r *exportRun) rate(asset string, timestamp int64) (float64, error) {
+	if asset == "" || asset == r.request.Quote {
+		return 1, nil
+	}
+
+	hour := time.UnixMilli(timestamp).Truncate(time.Hour).UnixMilli()
+	key := fmt.Sprintf("%s:%d", asset, hour)
+	if rate, ok := r.rates[key]; ok {
+		return rate, nil
+	}
+
+	rate, err := r.openPrice(asset+r.request.Quote, hour)
+	if err != nil {
+		inverse, inverseErr := r.openPrice(r.request.Quote+asset, hour)
+		if inverseErr != nil {
+			return 0, fmt.Errorf("can't convert %s to %s: %v", asset, r.request.Quote, err)
+		}
+		rate = 1 / inverse
+	}
+
+	r.rates[key] = rate
+	return rate, nil
+}
+
+func (r *exportRun) openPrice(symbol string, startTime int64) (float64, error) {
+	klines, err := r.client.NewKlinesService().
+		Symbol(symbol).
+		Interval("1h").
+		StartTime(startTime).
+		Limit(1).
+		Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(requestInterval)
+	if len(klines) == 0 {
+		return 0, fmt.Errorf("no %s price at %s", symbol, time.UnixMilli(startTime).UTC().Format(time.RFC3339))
+	}
+	price, err := strconv.ParseFloat(klines[0].Open, 64)
+	if err != nil || price == 0 {
+		return 0, fmt.Errorf("invalid %s price %q", symbol, klines[0].Open)
+	}
+	return price, nil
+}
+
+func marginAsset(symbol string) string {
+	for _, asset := range marginAssets {
+		if strings.HasSuffix(symbol, asset) {
+			return asset
+		}
+	}
+	return "USDT"
+}
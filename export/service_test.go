@@ -0,0 +1,98 @@
+package export
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRequest(t *testing.T) {
+	bangkok, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(daysAgo int) string {
+		return time.Now().UTC().AddDate(0, 0, -daysAgo).Format("2006-01-02")
+	}
+	day := func(daysAgo int, loc *time.Location) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02", date(daysAgo), loc)
+		return t
+	}
+
+	tests := []struct {
+		name        string
+		values      url.Values
+		wantFrom    time.Time
+		wantTo      time.Time // zero means now
+		wantLoc     *time.Location
+		wantQuote   string
+		wantSymbols []string
+		wantErr     bool
+	}{
+		{
+			name:      "from only",
+			values:    url.Values{"from": {date(10)}},
+			wantFrom:  day(10, time.UTC),
+			wantLoc:   time.UTC,
+			wantQuote: "USDT",
+		},
+		{
+			name:      "date to includes the whole day",
+			values:    url.Values{"from": {date(10)}, "to": {date(5)}},
+			wantFrom:  day(10, time.UTC),
+			wantTo:    day(4, time.UTC),
+			wantLoc:   time.UTC,
+			wantQuote: "USDT",
+		},
+		{
+			name:        "symbols, tz and quote",
+			values:      url.Values{"from": {date(10)}, "to": {date(5)}, "symbol": {"btcusdt, ethusdt,"}, "tz": {"Asia/Bangkok"}, "quote": {"busd"}},
+			wantFrom:    day(10, bangkok),
+			wantTo:      day(4, bangkok),
+			wantLoc:     bangkok,
+			wantQuote:   "BUSD",
+			wantSymbols: []string{"BTCUSDT", "ETHUSDT"},
+		},
+		{name: "missing from", values: url.Values{}, wantErr: true},
+		{name: "invalid from", values: url.Values{"from": {"yesterday"}}, wantErr: true},
+		{name: "invalid to", values: url.Values{"from": {date(10)}, "to": {"tomorrow"}}, wantErr: true},
+		{name: "invalid tz", values: url.Values{"from": {date(10)}, "tz": {"Mars/Olympus"}}, wantErr: true},
+		{name: "to before from", values: url.Values{"from": {date(5)}, "to": {date(10)}}, wantErr: true},
+		{name: "older than the trade history", values: url.Values{"from": {date(200)}, "to": {date(190)}}, wantErr: true},
+		{name: "range over the cap", values: url.Values{"from": {date(150)}, "to": {date(20)}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			got, err := ParseRequest(tt.values, time.UTC, "USDT")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequest(%v) error = %v, wantErr %t", tt.values, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !got.From.Equal(tt.wantFrom) {
+				t.Errorf("From = %s, want %s", got.From, tt.wantFrom)
+			}
+			if tt.wantTo.IsZero() {
+				if got.To.Before(before) || got.To.After(time.Now()) {
+					t.Errorf("To = %s, want now", got.To)
+				}
+			} else if !got.To.Equal(tt.wantTo) {
+				t.Errorf("To = %s, want %s", got.To, tt.wantTo)
+			}
+			if got.Location.String() != tt.wantLoc.String() {
+				t.Errorf("Location = %s, want %s", got.Location, tt.wantLoc)
+			}
+			if got.Quote != tt.wantQuote {
+				t.Errorf("Quote = %s, want %s", got.Quote, tt.wantQuote)
+			}
+			if !reflect.DeepEqual(got.Symbols, tt.wantSymbols) {
+				t.Errorf("Symbols = %v, want %v", got.Symbols, tt.wantSymbols)
+			}
+		})
+	}
+}
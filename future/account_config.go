@@ -0,0 +1,205 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// Binance error codes returned when the requested setting is already active
+const (
+	errCodeNoNeedToChangeMarginType   = -4046
+	errCodeNoNeedToChangePositionMode = -4059
+)
+
+type symbolConfig struct {
+	Leverage   int
+	MarginType futures.MarginType
+}
+
+// accountConfig caches the per-symbol leverage and margin type and the
+// account position mode, so tradeSetup only calls the change endpoints
+// when the desired value differs.
+type accountConfig struct {
+	mu       sync.RWMutex
+	loaded   bool
+	dualSide bool
+	symbols  map[string]*symbolConfig
+	// Leverage changes sent by the bot, their ACCOUNT_CONFIG_UPDATE isn't external
+	expectedLeverage map[string]int
+}
+
+func newAccountConfig() *accountConfig {
+	return &accountConfig{
+		symbols:          make(map[string]*symbolConfig),
+		expectedLeverage: make(map[string]int),
+	}
+}
+
+func (a *accountConfig) isLoaded() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.loaded
+}
+
+func (a *accountConfig) get(symbol string) (symbolConfig, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	c, ok := a.symbols[symbol]
+	if !ok {
+		return symbolConfig{}, false
+	}
+	return *c, true
+}
+
+func (a *accountConfig) isDualSide() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.dualSide
+}
+
+func (a *accountConfig) setDualSide(dualSide bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dualSide = dualSide
+}
+
+func (a *accountConfig) setLeverage(symbol string, leverage int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.symbols[symbol] == nil {
+		a.symbols[symbol] = &symbolConfig{}
+	}
+	a.symbols[symbol].Leverage = leverage
+}
+
+// normalizeMarginType maps the margin type of the position risk and of the
+// user data stream ("cross", "isolated") to the one of the change endpoint
+// ("CROSSED", "ISOLATED"), so the cached values compare
+func normalizeMarginType(marginType string) futures.MarginType {
+	if strings.EqualFold(marginType, string(futures.MarginTypeIsolated)) {
+		return futures.MarginTypeIsolated
+	}
+	return futures.MarginTypeCrossed
+}
+
+func (a *accountConfig) setMarginType(symbol string, marginType futures.MarginType) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.symbols[symbol] == nil {
+		a.symbols[symbol] = &symbolConfig{}
+	}
+	a.symbols[symbol].MarginType = marginType
+}
+
+func (a *accountConfig) expectLeverage(symbol string, leverage int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expectedLeverage[symbol] = leverage
+}
+
+// takeExpectedLeverage returns true, once, when the bot requested leverage for symbol
+func (a *accountConfig) takeExpectedLeverage(symbol string, leverage int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if expected, ok := a.expectedLeverage[symbol]; ok && expected == leverage {
+		delete(a.expectedLeverage, symbol)
+		return true
+	}
+	return false
+}
+
+// loadAccountConfig reads the position mode and the leverage and margin type
+// of every symbol once.
+func (s *service) loadAccountConfig() error {
+	positionMode, err := s.client.NewGetPositionModeService().Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	positions, err := s.client.NewGetPositionRiskService().Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	s.accountConfig.mu.Lock()
+	defer s.accountConfig.mu.Unlock()
+
+	s.accountConfig.dualSide = positionMode.DualSidePosition
+	for _, p := range positions {
+		leverage, _ := strconv.Atoi(p.Leverage)
+		s.accountConfig.symbols[p.Symbol] = &symbolConfig{
+			Leverage:   leverage,
+			MarginType: normalizeMarginType(p.MarginType),
+		}
+	}
+	s.accountConfig.loaded = true
+
+	log.Printf("Loaded account config: %d symbols, Dual Side: %t\n", len(positions), positionMode.DualSidePosition)
+	return nil
+}
+
+// ensureLeverage changes the symbol leverage only when it differs from the cached value
+func (s *service) ensureLeverage(symbol string, leverage int) error {
+	if c, ok := s.accountConfig.get(symbol); ok && c.Leverage == leverage {
+		return nil
+	}
+
+	s.accountConfig.expectLeverage(symbol, leverage)
+	respChangeLeverage, err := s.client.NewChangeLeverageService().
+		Symbol(symbol).
+		Leverage(leverage).
+		Do(context.Background())
+	if err != nil {
+		s.accountConfig.takeExpectedLeverage(symbol, leverage)
+		return err
+	}
+	fmt.Printf("Symbol: %s, Leverage: %d, MaxNotionalValue: %s\n", respChangeLeverage.Symbol, respChangeLeverage.Leverage, respChangeLeverage.MaxNotionalValue)
+
+	s.accountConfig.setLeverage(symbol, respChangeLeverage.Leverage)
+	return nil
+}
+
+// ensureMarginType changes the symbol margin type only when it differs from the cached value
+func (s *service) ensureMarginType(symbol string, marginType futures.MarginType) error {
+	if c, ok := s.accountConfig.get(symbol); ok && c.MarginType == marginType {
+		return nil
+	}
+
+	err := s.client.NewChangeMarginTypeService().
+		Symbol(symbol).
+		MarginType(marginType).
+		Do(context.Background())
+	if err != nil && !isAPIErrorCode(err, errCodeNoNeedToChangeMarginType) {
+		return err
+	}
+
+	s.accountConfig.setMarginType(symbol, marginType)
+	return nil
+}
+
+// ensureDualSide enables hedge mode only when the account is not already in it
+func (s *service) ensureDualSide() error {
+	if s.accountConfig.isLoaded() && s.accountConfig.isDualSide() {
+		return nil
+	}
+
+	err := s.client.NewChangePositionModeService().DualSide(true).Do(context.Background())
+	if err != nil && !isAPIErrorCode(err, errCodeNoNeedToChangePositionMode) {
+		return err
+	}
+
+	s.accountConfig.setDualSide(true)
+	return nil
+}
+
+func isAPIErrorCode(err error, code int64) bool {
+	apiErr, ok := err.(*common.APIError)
+	return ok && apiErr.Code == code
+}
@@ -0,0 +1,26 @@
+package future
+
+import (
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+func TestNormalizeMarginType(t *testing.T) {
+	tests := []struct {
+		input string
+		want  futures.MarginType
+	}{
+		{"cross", futures.MarginTypeCrossed},
+		{"CROSSED", futures.MarginTypeCrossed},
+		{"isolated", futures.MarginTypeIsolated},
+		{"ISOLATED", futures.MarginTypeIsolated},
+		{"", futures.MarginTypeCrossed},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeMarginType(tt.input); got != tt.want {
+			t.Errorf("normalizeMarginType(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
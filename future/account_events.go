@@ -0,0 +1,254 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+)
+
+// accountState keeps the balances and open positions reported by the
+// ACCOUNT_UPDATE events, and the funding fees paid or received
+type accountState struct {
+	mu            sync.RWMutex
+	balances      map[string]models.AccountBalance
+	positions     map[string]models.AccountPosition // by symbol and side
+	fundingFees   map[string]float64
+	lastFundingAt time.Time
+	updatedAt     time.Time
+}
+
+func newAccountState() *accountState {
+	return &accountState{
+		balances:    make(map[string]models.AccountBalance),
+		positions:   make(map[string]models.AccountPosition),
+		fundingFees: make(map[string]float64),
+	}
+}
+
+func positionKey(symbol, side string) string {
+	return symbol + ":" + side
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func (a *accountState) setBalance(b models.AccountBalance) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.balances[b.Asset] = b
+	a.updatedAt = b.UpdatedAt
+}
+
+// setPosition stores an open position and drops a closed one
+func (a *accountState) setPosition(p models.AccountPosition) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := positionKey(p.Symbol, p.Side)
+	if p.Amount == 0 {
+		delete(a.positions, key)
+	} else {
+		a.positions[key] = p
+	}
+	a.updatedAt = p.UpdatedAt
+}
+
+func (a *accountState) addFundingFee(asset string, fee float64, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fundingFees[asset] += fee
+	a.lastFundingAt = at
+}
+
+func (a *accountState) snapshot() models.AccountSnapshot {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	snapshot := models.AccountSnapshot{
+		Balances:      []models.AccountBalance{},
+		Positions:     []models.AccountPosition{},
+		FundingFees:   make(map[string]float64),
+		LastFundingAt: a.lastFundingAt,
+		UpdatedAt:     a.updatedAt,
+	}
+	for _, b := range a.balances {
+		snapshot.Balances = append(snapshot.Balances, b)
+	}
+	for _, p := range a.positions {
+		snapshot.Positions = append(snapshot.Positions, p)
+	}
+	for asset, fee := range a.fundingFees {
+		snapshot.FundingFees[asset] = fee
+	}
+	sort.Slice(snapshot.Balances, func(i, j int) bool {
+		return snapshot.Balances[i].Asset < snapshot.Balances[j].Asset
+	})
+	sort.Slice(snapshot.Positions, func(i, j int) bool {
+		return positionKey(snapshot.Positions[i].Symbol, snapshot.Positions[i].Side) < positionKey(snapshot.Positions[j].Symbol, snapshot.Positions[j].Side)
+	})
+	return snapshot
+}
+
+func (s *service) AccountSnapshot() models.AccountSnapshot {
+	return s.account.snapshot()
+}
+
+// loadAccountState reads the balances and the open positions once, the
+// ACCOUNT_UPDATE events keep them up to date afterwards
+func (s *service) loadAccountState() error {
+	account, err := s.client.NewGetAccountService().Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, asset := range account.Assets {
+		s.account.setBalance(models.AccountBalance{
+			Asset:         asset.Asset,
+			WalletBalance: parseFloat(asset.WalletBalance),
+			UpdatedAt:     now,
+		})
+	}
+	for _, p := range account.Positions {
+		marginType := futures.MarginTypeCrossed
+		if p.Isolated {
+			marginType = futures.MarginTypeIsolated
+		}
+		s.account.setPosition(models.AccountPosition{
+			Symbol:         p.Symbol,
+			Side:           string(p.PositionSide),
+			Amount:         parseFloat(p.PositionAmt),
+			EntryPrice:     parseFloat(p.EntryPrice),
+			UnrealizedPnl:  parseFloat(p.UnrealizedProfit),
+			MarginType:     string(marginType),
+			IsolatedWallet: parseFloat(p.IsolatedWallet),
+			UpdatedAt:      now,
+		})
+	}
+	return nil
+}
+
+// handleAccountUpdate applies the balance and position changes of an
+// ACCOUNT_UPDATE, funding fee payments are noted
+func (s *service) handleAccountUpdate(event *futures.WsUserDataEvent) {
+	update := event.AccountUpdate
+	at := time.UnixMilli(event.TransactionTime)
+
+	for _, b := range update.Balances {
+		s.account.setBalance(models.AccountBalance{
+			Asset:              b.Asset,
+			WalletBalance:      parseFloat(b.Balance),
+			CrossWalletBalance: parseFloat(b.CrossWalletBalance),
+			UpdatedAt:          at,
+		})
+	}
+	for _, p := range update.Positions {
+		s.account.setPosition(models.AccountPosition{
+			Symbol:         p.Symbol,
+			Side:           string(p.Side),
+			Amount:         parseFloat(p.Amount),
+			EntryPrice:     parseFloat(p.EntryPrice),
+			UnrealizedPnl:  parseFloat(p.UnrealizedPnL),
+			MarginType:     string(normalizeMarginType(string(p.MarginType))),
+			IsolatedWallet: parseFloat(p.IsolatedWallet),
+			UpdatedAt:      at,
+		})
+	}
+
+	switch update.Reason {
+	case futures.UserDataEventReasonTypeMarginTypeChange:
+		// Keep the cached account config in sync with changes made outside the bot
+		for _, p := range update.Positions {
+			s.accountConfig.setMarginType(p.Symbol, normalizeMarginType(string(p.MarginType)))
+		}
+
+	case futures.UserDataEventReasonTypeFundingFee:
+		var lines []string
+		for _, b := range update.Balances {
+			fee := parseFloat(b.ChangeBalance)
+			if fee == 0 {
+				continue
+			}
+			s.account.addFundingFee(b.Asset, fee, at)
+			lines = append(lines, fmt.Sprintf("%s %s (wallet %s)", strconv.FormatFloat(fee, 'f', -1, 64), b.Asset, b.Balance))
+		}
+		var symbols []string
+		for _, p := range update.Positions {
+			symbols = append(symbols, p.Symbol)
+		}
+		if len(lines) > 0 {
+			msg := fmt.Sprintf("💸 Funding fee %s: %s", strings.Join(symbols, ","), strings.Join(lines, ", "))
+			log.Println(msg)
+			s.lineService.Notify(msg)
+		}
+
+	default:
+		log.Printf("Account update %s: %d balances, %d positions\n", update.Reason, len(update.Balances), len(update.Positions))
+	}
+}
+
+// handleMarginCall sends an urgent alert with the positions at risk
+func (s *service) handleMarginCall(event *futures.WsUserDataEvent) {
+	lines := []string{"🚨🚨🚨 MARGIN CALL 🚨🚨🚨"}
+	if event.CrossWalletBalance != "" {
+		lines = append(lines, fmt.Sprintf("Cross wallet: %s", event.CrossWalletBalance))
+	}
+	for _, p := range event.MarginCallPositions {
+		line := fmt.Sprintf("%s [%s] %s %s\nmark: %s, uPnL: %s\nmaintenance margin: %s",
+			p.Symbol,
+			p.Side,
+			normalizeMarginType(string(p.MarginType)),
+			p.Amount,
+			p.MarkPrice,
+			p.UnrealizedPnL,
+			p.MaintenanceMarginRequired,
+		)
+		if p.IsolatedWallet != "" && parseFloat(p.IsolatedWallet) != 0 {
+			line += fmt.Sprintf(", isolated wallet: %s", p.IsolatedWallet)
+		}
+		lines = append(lines, line)
+	}
+
+	msg := strings.Join(lines, "\n")
+	log.Println(msg)
+	s.lineService.Notify(msg)
+}
+
+// handleAccountConfigUpdate tracks the leverage changes, those the bot didn't
+// request are notified and audited
+func (s *service) handleAccountConfigUpdate(event *futures.WsUserDataEvent) {
+	update := event.AccountConfigUpdate
+	if update.Symbol == "" {
+		// Multi-assets mode change, nothing cached
+		log.Printf("Account config update: %+v\n", update)
+		return
+	}
+
+	leverage := int(update.Leverage)
+	previous, _ := s.accountConfig.get(update.Symbol)
+	expected := s.accountConfig.takeExpectedLeverage(update.Symbol, leverage)
+	s.accountConfig.setLeverage(update.Symbol, leverage)
+	if expected || previous.Leverage == leverage {
+		return
+	}
+
+	msg := fmt.Sprintf("%s ⚙️ Leverage changed outside the bot: %dx → %dx", update.Symbol, previous.Leverage, leverage)
+	log.Println(msg)
+	s.lineService.Notify(msg)
+	s.audit.Record("binance", constants.AuditActionConfig, map[string]interface{}{
+		"symbol":            update.Symbol,
+		"leverage":          leverage,
+		"previous_leverage": previous.Leverage,
+	})
+}
@@ -0,0 +1,126 @@
+package future
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/utils"
+)
+
+// positionMove returns the move of mark price from entry price in percent and
+// the ROE in percent of the initial margin, both positive when in profit
+func positionMove(side futures.PositionSideType, entryPrice, markPrice float64, leverage int) (float64, float64) {
+	move := (markPrice - entryPrice) / entryPrice * 100
+	if side == futures.PositionSideTypeShort {
+		move = -move
+	}
+	return move, move * float64(leverage)
+}
+
+// addOnState returns how many times the open position of symbol and side was
+// added to and the price of its last entry or add-on, 0 when unknown
+func (s *service) addOnState(symbol string, side futures.PositionSideType) (int, float64) {
+	orderBook, _ := s.store.GetOrderBook(cooldownKey(symbol, side))
+	return orderBook.Adds, orderBook.LastPrice
+}
+
+// checkAddOn applies the add-on policy when the entry adds to an open
+// position, it returns whether the entry is an add-on
+func (s *service) checkAddOn(plan *entryPlan, positionRisk *futures.PositionRisk) (bool, error) {
+	var amount, entryPrice, markPrice, isolatedWallet float64
+	if positionRisk != nil {
+		if f, err := strconv.ParseFloat(positionRisk.PositionAmt, 64); err == nil {
+			amount = math.Abs(f)
+		}
+		if f, err := strconv.ParseFloat(positionRisk.EntryPrice, 64); err == nil {
+			entryPrice = f
+		}
+		if f, err := strconv.ParseFloat(positionRisk.MarkPrice, 64); err == nil {
+			markPrice = f
+		}
+		if f, err := strconv.ParseFloat(positionRisk.IsolatedWallet, 64); err == nil {
+			isolatedWallet = f
+		}
+	}
+
+	// New position
+	if amount == 0 || entryPrice == 0 {
+		return false, nil
+	}
+
+	leverage := plan.Leverage
+	if l, err := strconv.Atoi(positionRisk.Leverage); err == nil && l > 0 {
+		leverage = l
+	}
+	adds, lastPrice := s.addOnState(plan.Symbol, plan.Side)
+	_, roe := positionMove(plan.Side, entryPrice, markPrice, leverage)
+	mode := s.config.AddOnMode
+
+	// The distance is measured from the last add, so adds can't stack at one
+	// price as the average entry follows them. A position the bot didn't
+	// enter falls back to its entry price.
+	if lastPrice == 0 {
+		lastPrice = entryPrice
+	}
+	move, _ := positionMove(plan.Side, lastPrice, markPrice, leverage)
+
+	status := fmt.Sprintf("Add-on %d (%s), Entry Price: %f, Last Price: %f, Mark Price: %f, Move: %.2f%%, ROE: %.2f%%",
+		adds+1, mode, entryPrice, lastPrice, markPrice, move, roe)
+
+	reject := func(reason string) (bool, error) {
+		err := fmt.Errorf("%s, rejected: %s", status, reason)
+		log.Printf("AddOn: %s [%s] %s\n", plan.Symbol, plan.Side, err)
+		s.lineService.Notify(fmt.Sprintf("%s [%s] ➕ %s", plan.Symbol, plan.Side, err))
+		return true, err
+	}
+
+	if mode == constants.AddOnModeNone {
+		return reject("adding to an open position is disabled")
+	}
+
+	if s.config.AddOnMaxAdds > 0 && adds >= s.config.AddOnMaxAdds {
+		return reject(fmt.Sprintf("%d adds reached, max %d", adds, s.config.AddOnMaxAdds))
+	}
+
+	// Average adds only when the position is losing enough, pyramid only when it's winning enough
+	distance, roeDistance := -move, -roe
+	if mode == constants.AddOnModePyramid {
+		distance, roeDistance = move, roe
+	}
+	if distance < s.config.AddOnMinDistancePercentage {
+		return reject(fmt.Sprintf("price moved %.2f%% in the %s direction, min %.2f%%", distance, mode, s.config.AddOnMinDistancePercentage))
+	}
+	if roeDistance < s.config.AddOnMinRoePercentage {
+		return reject(fmt.Sprintf("ROE moved %.2f%% in the %s direction, min %.2f%%", roeDistance, mode, s.config.AddOnMinRoePercentage))
+	}
+
+	plan.Quantity = utils.Floor(plan.Quantity*s.config.AddOnSizeMultiplier, plan.QuantityPrecision)
+	if plan.Quantity <= 0 {
+		return reject(fmt.Sprintf("size %.2fx is below one lot", s.config.AddOnSizeMultiplier))
+	}
+	note := fmt.Sprintf("%s, size %.2fx", status, s.config.AddOnSizeMultiplier)
+
+	// Total margin of the position after the add
+	if s.config.AddOnMaxMargin > 0 {
+		headroom := s.config.AddOnMaxMargin - isolatedWallet
+		if headroom <= 0 {
+			return reject(fmt.Sprintf("position margin $%.2f reached, max $%.2f", isolatedWallet, s.config.AddOnMaxMargin))
+		}
+		if margin := plan.Margin(); margin > headroom {
+			plan.Quantity = utils.Floor(plan.Quantity*headroom/margin, plan.QuantityPrecision)
+			if plan.Quantity <= 0 {
+				return reject(fmt.Sprintf("margin headroom $%.2f is below one lot", headroom))
+			}
+			note += fmt.Sprintf(", shrunk to fit margin $%.2f/$%.2f", isolatedWallet, s.config.AddOnMaxMargin)
+		}
+	}
+
+	log.Printf("AddOn: %s [%s] %s\n", plan.Symbol, plan.Side, note)
+	plan.Notes = append(plan.Notes, note)
+	return true, nil
+}
@@ -0,0 +1,117 @@
+package future
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+)
+
+func TestCheckAddOn(t *testing.T) {
+	// A long entered at 110 and marked at 100, 9.09% and 90.9% ROE against it
+	positionRisk := &futures.PositionRisk{
+		Symbol:         "BTCUSDT",
+		PositionSide:   "LONG",
+		PositionAmt:    "1",
+		EntryPrice:     "110",
+		MarkPrice:      "100",
+		IsolatedWallet: "11",
+		Leverage:       "10",
+	}
+
+	tests := []struct {
+		name              string
+		config            models.EnvConfig
+		quantityPrecision int
+		lastPrice         float64
+		wantQuantity      float64
+		wantErr           bool
+	}{
+		{
+			name:              "average down",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModeAverage, AddOnSizeMultiplier: 1, AddOnMinDistancePercentage: 2},
+			quantityPrecision: 3,
+			wantQuantity:      1,
+		},
+		{
+			name:              "disabled",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModeNone, AddOnSizeMultiplier: 1},
+			quantityPrecision: 3,
+			wantErr:           true,
+		},
+		{
+			name:              "distance from the last add",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModeAverage, AddOnSizeMultiplier: 1, AddOnMinDistancePercentage: 2},
+			quantityPrecision: 3,
+			lastPrice:         101,
+			wantErr:           true,
+		},
+		{
+			name:              "size multiplier is floored",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModeAverage, AddOnSizeMultiplier: 0.4567},
+			quantityPrecision: 2,
+			wantQuantity:      0.45,
+		},
+		{
+			name:              "shrink to the margin cap is floored",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModeAverage, AddOnSizeMultiplier: 1, AddOnMaxMargin: 16.55},
+			quantityPrecision: 2,
+			wantQuantity:      0.55,
+		},
+		{
+			name:              "shrink below one lot",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModeAverage, AddOnSizeMultiplier: 1, AddOnMaxMargin: 16},
+			quantityPrecision: 0,
+			wantErr:           true,
+		},
+		{
+			name:              "pyramid on a losing position",
+			config:            models.EnvConfig{AddOnMode: constants.AddOnModePyramid, AddOnSizeMultiplier: 1},
+			quantityPrecision: 3,
+			wantErr:           true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			s := newTestService(&config)
+			if tt.lastPrice > 0 {
+				s.store.UpdateOrderBook(cooldownKey("BTCUSDT", futures.PositionSideTypeLong), func(orderBook *models.OrderBook) {
+					orderBook.LastPrice = tt.lastPrice
+				})
+			}
+			plan := &entryPlan{
+				Symbol:            "BTCUSDT",
+				Side:              futures.PositionSideTypeLong,
+				Quantity:          1,
+				MarkPrice:         100,
+				Leverage:          10,
+				QuantityPrecision: tt.quantityPrecision,
+			}
+
+			isAddOn, err := s.checkAddOn(plan, positionRisk)
+			if !isAddOn {
+				t.Errorf("checkAddOn() isAddOn = false, want true")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkAddOn() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && math.Abs(plan.Quantity-tt.wantQuantity) > 1e-9 {
+				t.Errorf("checkAddOn() quantity = %v, want %v", plan.Quantity, tt.wantQuantity)
+			}
+		})
+	}
+}
+
+func TestCheckAddOnNewPosition(t *testing.T) {
+	s := newTestService(&models.EnvConfig{AddOnMode: constants.AddOnModeNone})
+	plan := &entryPlan{Symbol: "BTCUSDT", Side: futures.PositionSideTypeLong, Quantity: 1}
+	flat := &futures.PositionRisk{PositionAmt: "0", EntryPrice: "0"}
+	if isAddOn, err := s.checkAddOn(plan, flat); isAddOn || err != nil {
+		t.Errorf("checkAddOn() = %t, %v, want a new position", isAddOn, err)
+	}
+}
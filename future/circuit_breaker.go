@@ -0,0 +1,163 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/utils"
+)
+
+// circuitBreaker halts new entries once the day's loss exceeds the configured limit
+type circuitBreaker struct {
+	mu      sync.Mutex
+	tripped bool
+	reason  string
+}
+
+func (c *circuitBreaker) isTripped() (bool, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tripped, c.reason
+}
+
+// trip returns false when the breaker was already tripped
+func (c *circuitBreaker) trip(reason string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tripped {
+		return false
+	}
+	c.tripped = true
+	c.reason = reason
+	return true
+}
+
+// reset returns false when the breaker was not tripped
+func (c *circuitBreaker) reset() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.tripped {
+		return false
+	}
+	c.tripped = false
+	c.reason = ""
+	return true
+}
+
+func (s *service) isDailyLossLimitEnabled() bool {
+	return s.config.DailyLossLimit > 0 || s.config.DailyLossLimitPercentage > 0
+}
+
+// tradingDayStart returns the start of the current trading day
+func (s *service) tradingDayStart() time.Time {
+	return utils.TradingDayStart(time.Now(), s.config.Location, s.config.DailyResetTime)
+}
+
+// checkDailyLoss returns an error when new entries are halted, it trips the
+// breaker when the day's net loss exceeds the absolute or percentage limit
+func (s *service) checkDailyLoss() error {
+	if !s.isDailyLossLimitEnabled() {
+		return nil
+	}
+
+	if tripped, reason := s.circuitBreaker.isTripped(); tripped {
+		return fmt.Errorf("Trading halted: %s", reason)
+	}
+
+	realizedPnl, err := s.calculateRealizedPnl()
+	if err != nil {
+		return err
+	}
+	pnl := realizedPnl.NetProfit
+
+	if s.config.DailyLossIncludeUnrealized {
+		positions, err := s.listOpenPositions()
+		if err != nil {
+			return err
+		}
+		for _, p := range positions {
+			if f, err := strconv.ParseFloat(p.UnRealizedProfit, 64); err == nil && f < 0 {
+				pnl += f
+			}
+		}
+	}
+
+	if pnl >= 0 {
+		return nil
+	}
+	loss := math.Abs(pnl)
+
+	var reason string
+	if s.config.DailyLossLimit > 0 && loss >= s.config.DailyLossLimit {
+		reason = fmt.Sprintf("daily loss $%.2f reached limit $%.2f", loss, s.config.DailyLossLimit)
+	} else if s.config.DailyLossLimitPercentage > 0 {
+		account, err := s.client.NewGetAccountService().Do(context.Background())
+		if err != nil {
+			return err
+		}
+
+		var walletBalance float64
+		if f, err := strconv.ParseFloat(account.TotalWalletBalance, 64); err == nil {
+			walletBalance = f
+		}
+
+		// Balance at the start of the day, before today's realized PnL
+		startBalance := walletBalance - realizedPnl.NetProfit
+		if startBalance > 0 {
+			lossPercentage := loss / startBalance * 100
+			if lossPercentage >= s.config.DailyLossLimitPercentage {
+				reason = fmt.Sprintf("daily loss %.2f%% ($%.2f) reached limit %.2f%%", lossPercentage, loss, s.config.DailyLossLimitPercentage)
+			}
+		}
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	if s.circuitBreaker.trip(reason) {
+		s.onDailyLossTripped(reason)
+	}
+	return fmt.Errorf("Trading halted: %s", reason)
+}
+
+func (s *service) onDailyLossTripped(reason string) {
+	log.Println("Circuit breaker tripped: ", reason)
+
+	msg := fmt.Sprintf(`🛑 CIRCUIT BREAKER
+%s
+New entries are halted until %s`,
+		reason,
+		s.tradingDayStart().AddDate(0, 0, 1).Format("2006-01-02 15:04 MST"),
+	)
+
+	if s.config.DailyLossFlatten && s.TradingState("") == constants.TradingStatePaused {
+		msg += "\nTrading is paused, positions are left open"
+	} else if s.config.DailyLossFlatten {
+		failed, err := s.closeAllPositions()
+		if err != nil {
+			msg += fmt.Sprintf("\nClose all positions failed: %s", err)
+		} else if len(failed) > 0 {
+			msg += fmt.Sprintf("\nClose positions failed: %s", strings.Join(failed, ", "))
+		} else {
+			msg += "\nAll positions closed"
+		}
+	}
+
+	s.lineService.Notify(msg)
+}
+
+// resetDailyLoss runs at the day boundary
+func (s *service) resetDailyLoss() {
+	if s.circuitBreaker.reset() {
+		log.Println("Circuit breaker reset")
+		s.lineService.Notify("🟢 CIRCUIT BREAKER RESET\nNew entries are allowed again")
+	}
+}
@@ -0,0 +1,120 @@
+package future
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/models"
+)
+
+// cooldownDuration returns the cooldown of symbol and side, the most specific
+// of "SYMBOL/SIDE", "SYMBOL", "SIDE" and the default wins
+func (s *service) cooldownDuration(symbol string, side futures.PositionSideType) time.Duration {
+	if d, ok := s.config.CooldownSymbols[symbol+"/"+string(side)]; ok {
+		return d
+	}
+	if d, ok := s.config.CooldownSymbols[symbol]; ok {
+		return d
+	}
+	if d, ok := s.config.CooldownSides[string(side)]; ok {
+		return d
+	}
+	return s.config.Cooldown
+}
+
+func cooldownKey(symbol string, side futures.PositionSideType) string {
+	return symbol + string(side)
+}
+
+// checkCooldown rejects the entry during the cooldown after the last entry,
+// when the rolling window is full, or during a lockout after a stop-out
+func (s *service) checkCooldown(command *models.Command) error {
+	orderBook, ok := s.store.GetOrderBook(cooldownKey(command.Symbol, command.Side))
+	if !ok { // First
+		return nil
+	}
+
+	now := time.Now()
+	if now.Before(orderBook.LockoutUntil) {
+		return fmt.Errorf("Cooldown: %s [%s] locked out after stop-out for %s", command.Symbol, command.Side, orderBook.LockoutUntil.Sub(now).Round(time.Second))
+	}
+
+	cooldown := s.cooldownDuration(command.Symbol, command.Side)
+	if diff := now.Sub(orderBook.TimeStamp); diff < cooldown {
+		return fmt.Errorf("Cooldown: %s [%s] last entry %s ago, cooldown %s", command.Symbol, command.Side, diff.Round(time.Second), cooldown)
+	}
+
+	if s.config.MaxEntriesPerWindow > 0 {
+		var count int
+		for _, t := range orderBook.Entries {
+			if now.Sub(t) < s.config.EntryWindow {
+				count++
+			}
+		}
+		if count >= s.config.MaxEntriesPerWindow {
+			return fmt.Errorf("Cooldown: %s [%s] %d entries in the last %s, max %d", command.Symbol, command.Side, count, s.config.EntryWindow, s.config.MaxEntriesPerWindow)
+		}
+	}
+
+	return nil
+}
+
+// recordEntry starts the cooldown, counts the add-ons and keeps the entry
+// price, it's called only after the entry order succeeded
+func (s *service) recordEntry(symbol string, side futures.PositionSideType, isAddOn bool, price float64) {
+	now := time.Now()
+	err := s.store.UpdateOrderBook(cooldownKey(symbol, side), func(orderBook *models.OrderBook) {
+		orderBook.Side = string(side)
+		orderBook.TimeStamp = now
+		if isAddOn {
+			orderBook.Adds++
+		} else {
+			orderBook.Adds = 0
+		}
+		orderBook.LastPrice = price
+
+		// Keep only the entries inside the rolling window
+		entries := orderBook.Entries[:0]
+		for _, t := range orderBook.Entries {
+			if now.Sub(t) < s.config.EntryWindow {
+				entries = append(entries, t)
+			}
+		}
+		orderBook.Entries = append(entries, now)
+	})
+	if err != nil {
+		log.Println("RecordEntry: ", err)
+	}
+}
+
+// recordStopOut locks the symbol and side out of new entries after a stop-loss or liquidation fill
+func (s *service) recordStopOut(symbol string, side futures.PositionSideType) {
+	if s.config.StopOutLockout <= 0 {
+		return
+	}
+
+	err := s.store.UpdateOrderBook(cooldownKey(symbol, side), func(orderBook *models.OrderBook) {
+		orderBook.Side = string(side)
+		orderBook.LockoutUntil = time.Now().Add(s.config.StopOutLockout)
+	})
+	if err != nil {
+		log.Println("RecordStopOut: ", err)
+	}
+}
+
+// isStopOut returns true for fills of our stop-loss orders and of liquidation orders
+func isStopOut(o futures.WsOrderTradeUpdate) bool {
+	if o.ExecutionType != futures.OrderExecutionTypeTrade {
+		return false
+	}
+	return o.OriginalType == futures.OrderTypeStopMarket || isLiquidation(o)
+}
+
+// isLiquidation returns true for liquidation and ADL orders
+func isLiquidation(o futures.WsOrderTradeUpdate) bool {
+	return strings.HasPrefix(o.ClientOrderID, "autoclose-") || strings.HasPrefix(o.ClientOrderID, "adl_autoclose")
+}
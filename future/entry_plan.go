@@ -0,0 +1,113 @@
+package future
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/utils"
+)
+
+// entryPlan is the order the entry path is about to send, the pre-trade
+// checks may reject it or adjust its quantity
+type entryPlan struct {
+	Symbol            string
+	Side              futures.PositionSideType
+	Quantity          float64
+	MarkPrice         float64
+	LimitPrice        float64 // 0 for a market entry
+	Leverage          int
+	PricePrecision    int
+	QuantityPrecision int
+	TpSlMode          string
+	FundingRate       float64 // last funding rate, positive means longs pay shorts
+	NextFundingTime   time.Time
+	RiskStopLoss      float64 // stop of the risk per trade sizing, 0 without it
+	RiskEquity        float64
+	RiskCapped        bool
+	Notes             []string // reported in the entry notification
+}
+
+// EntryPrice returns the expected entry price
+func (p *entryPlan) EntryPrice() float64 {
+	if p.LimitPrice > 0 {
+		return p.LimitPrice
+	}
+	return p.MarkPrice
+}
+
+// Notional returns the position size in USD
+func (p *entryPlan) Notional() float64 {
+	return p.Quantity * p.EntryPrice()
+}
+
+// FundingCost returns the estimated payment of the next funding, negative when the position receives funding
+func (p *entryPlan) FundingCost() float64 {
+	cost := p.Notional() * p.FundingRate
+	if p.Side == futures.PositionSideTypeShort {
+		return -cost
+	}
+	return cost
+}
+
+// Margin returns the initial margin the order requires
+func (p *entryPlan) Margin() float64 {
+	if p.Leverage == 0 {
+		return p.Notional()
+	}
+	return p.Notional() / float64(p.Leverage)
+}
+
+// RiskNote describes the loss at the stop of a risk sized entry, from the
+// quantity about to be sent as the later checks may have shrunk it
+func (p *entryPlan) RiskNote() string {
+	if p.RiskStopLoss == 0 || p.RiskEquity == 0 {
+		return ""
+	}
+
+	risk := utils.ToFixed(p.Quantity, p.QuantityPrecision) * math.Abs(p.EntryPrice()-p.RiskStopLoss)
+	note := fmt.Sprintf("Risk: $%.2f (%.2f%% of equity $%.2f), Stop: %s",
+		risk,
+		risk/p.RiskEquity*100,
+		p.RiskEquity,
+		utils.FormatFloat(p.RiskStopLoss, p.PricePrecision),
+	)
+	if p.RiskCapped {
+		note += ", capped by margin/exposure"
+	}
+	return note
+}
+
+// notifyEntry reports the sizing decisions of the entry, the fill itself is
+// reported by the user data stream
+func (s *service) notifyEntry(plan *entryPlan) {
+	notes := plan.Notes
+	if !plan.NextFundingTime.IsZero() {
+		notes = append(notes, fmt.Sprintf("Funding: %.4f%% in %s, est. cost $%.4f",
+			plan.FundingRate*100,
+			time.Until(plan.NextFundingTime).Round(time.Minute),
+			plan.FundingCost(),
+		))
+	}
+	if len(notes) == 0 {
+		return
+	}
+
+	msg := fmt.Sprintf(`%s [%s] 📝 Entry
+Quantity: %s
+Notional: $%.2f
+Leverage: %dx
+%s`,
+		plan.Symbol,
+		plan.Side,
+		utils.FormatFloat(plan.Quantity, plan.QuantityPrecision),
+		plan.Notional(),
+		plan.Leverage,
+		strings.Join(notes, "\n"),
+	)
+
+	s.lineService.Notify(msg)
+}
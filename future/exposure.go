@@ -0,0 +1,197 @@
+package future
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/models"
+)
+
+// exposure summarizes the open positions of the account
+type exposure struct {
+	Positions      int
+	LongPositions  int
+	ShortPositions int
+	TotalNotional  float64
+	SymbolNotional map[string]float64
+	open           map[string]bool // symbol + side
+	positions      []exposurePosition
+}
+
+type exposurePosition struct {
+	Symbol   string
+	Side     futures.PositionSideType
+	Notional float64
+}
+
+func newExposure(positions []*futures.PositionRisk) *exposure {
+	e := &exposure{
+		SymbolNotional: make(map[string]float64),
+		open:           make(map[string]bool),
+	}
+
+	for _, p := range positions {
+		amount, err := strconv.ParseFloat(p.PositionAmt, 64)
+		if err != nil || amount == 0 {
+			continue
+		}
+
+		var notional float64
+		if f, err := strconv.ParseFloat(p.Notional, 64); err == nil {
+			notional = math.Abs(f)
+		}
+
+		side := futures.PositionSideTypeLong
+		if p.PositionSide == string(futures.PositionSideTypeShort) || (p.PositionSide == string(futures.PositionSideTypeBoth) && amount < 0) {
+			side = futures.PositionSideTypeShort
+		}
+
+		e.Positions++
+		if side == futures.PositionSideTypeShort {
+			e.ShortPositions++
+		} else {
+			e.LongPositions++
+		}
+		e.positions = append(e.positions, exposurePosition{Symbol: p.Symbol, Side: side, Notional: notional})
+		e.TotalNotional += notional
+		e.SymbolNotional[p.Symbol] += notional
+		e.open[p.Symbol+p.PositionSide] = true
+	}
+
+	return e
+}
+
+func (e *exposure) isOpen(symbol string, side futures.PositionSideType) bool {
+	return e.open[symbol+string(side)]
+}
+
+// hasExposureCaps returns true when a cap counts positions of several symbols
+func (s *service) hasExposureCaps() bool {
+	return s.config.MaxOpenPositions > 0 || s.config.MaxLongPositions > 0 || s.config.MaxShortPositions > 0 ||
+		s.config.MaxTotalNotional > 0 || len(s.config.SymbolGroups) > 0
+}
+
+// lockExposure serializes the entries from the position snapshot until their
+// order is sent, so a burst of alerts on different symbols can't all pass the
+// account and group caps. The returned unlock can be called more than once.
+func (s *service) lockExposure() func() {
+	if !s.hasExposureCaps() {
+		return func() {}
+	}
+	s.exposureMu.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(s.exposureMu.Unlock)
+	}
+}
+
+// checkExposure rejects the entry when it would breach one of the account
+// level position count or notional caps, 0 disables a cap
+func (s *service) checkExposure(plan *entryPlan, e *exposure) error {
+
+	// Adding to an existing position doesn't open a new one
+	if !e.isOpen(plan.Symbol, plan.Side) {
+		if s.config.MaxOpenPositions > 0 && e.Positions+1 > s.config.MaxOpenPositions {
+			return fmt.Errorf("Exposure: %d open positions, max %d", e.Positions, s.config.MaxOpenPositions)
+		}
+
+		if plan.Side == futures.PositionSideTypeLong && s.config.MaxLongPositions > 0 && e.LongPositions+1 > s.config.MaxLongPositions {
+			return fmt.Errorf("Exposure: %d long positions, max %d", e.LongPositions, s.config.MaxLongPositions)
+		}
+
+		if plan.Side == futures.PositionSideTypeShort && s.config.MaxShortPositions > 0 && e.ShortPositions+1 > s.config.MaxShortPositions {
+			return fmt.Errorf("Exposure: %d short positions, max %d", e.ShortPositions, s.config.MaxShortPositions)
+		}
+	}
+
+	notional := plan.Notional()
+	if s.config.MaxSymbolNotional > 0 && e.SymbolNotional[plan.Symbol]+notional > s.config.MaxSymbolNotional {
+		return fmt.Errorf("Exposure: %s notional $%.2f + $%.2f exceeds max $%.2f", plan.Symbol, e.SymbolNotional[plan.Symbol], notional, s.config.MaxSymbolNotional)
+	}
+
+	if s.config.MaxTotalNotional > 0 && e.TotalNotional+notional > s.config.MaxTotalNotional {
+		return fmt.Errorf("Exposure: total notional $%.2f + $%.2f exceeds max $%.2f", e.TotalNotional, notional, s.config.MaxTotalNotional)
+	}
+
+	return s.checkGroupExposure(plan, e)
+}
+
+// groupExposure summarizes the open positions of one symbol group
+type groupExposure struct {
+	Positions      int
+	LongPositions  int
+	ShortPositions int
+	Notional       float64
+}
+
+func (e *exposure) group(group models.SymbolGroup) groupExposure {
+	var g groupExposure
+	for _, p := range e.positions {
+		if !group.Contains(p.Symbol) {
+			continue
+		}
+		g.Positions++
+		if p.Side == futures.PositionSideTypeShort {
+			g.ShortPositions++
+		} else {
+			g.LongPositions++
+		}
+		g.Notional += p.Notional
+	}
+	return g
+}
+
+// checkGroupExposure applies the limits of every group the symbol belongs to,
+// correlated symbols count as one bet
+func (s *service) checkGroupExposure(plan *entryPlan, e *exposure) error {
+	isNew := !e.isOpen(plan.Symbol, plan.Side)
+
+	for _, group := range s.config.SymbolGroups {
+		if !group.Contains(plan.Symbol) {
+			continue
+		}
+		g := e.group(group)
+
+		if isNew {
+			if group.MaxPositions > 0 && g.Positions+1 > group.MaxPositions {
+				return fmt.Errorf("Exposure: group %s has %d open positions, max %d", group.Name, g.Positions, group.MaxPositions)
+			}
+
+			if plan.Side == futures.PositionSideTypeLong && group.MaxLongPositions > 0 && g.LongPositions+1 > group.MaxLongPositions {
+				return fmt.Errorf("Exposure: group %s has %d long positions, max %d", group.Name, g.LongPositions, group.MaxLongPositions)
+			}
+
+			if plan.Side == futures.PositionSideTypeShort && group.MaxShortPositions > 0 && g.ShortPositions+1 > group.MaxShortPositions {
+				return fmt.Errorf("Exposure: group %s has %d short positions, max %d", group.Name, g.ShortPositions, group.MaxShortPositions)
+			}
+		}
+
+		if group.MaxNotional > 0 && g.Notional+plan.Notional() > group.MaxNotional {
+			return fmt.Errorf("Exposure: group %s notional $%.2f + $%.2f exceeds max $%.2f", group.Name, g.Notional, plan.Notional(), group.MaxNotional)
+		}
+	}
+
+	return nil
+}
+
+// notionalHeadroom returns how much notional the entry can add before hitting
+// the symbol, total or group notional cap
+func (s *service) notionalHeadroom(plan *entryPlan, e *exposure) float64 {
+	headroom := math.Inf(1)
+	if s.config.MaxSymbolNotional > 0 {
+		headroom = math.Min(headroom, s.config.MaxSymbolNotional-e.SymbolNotional[plan.Symbol])
+	}
+	if s.config.MaxTotalNotional > 0 {
+		headroom = math.Min(headroom, s.config.MaxTotalNotional-e.TotalNotional)
+	}
+	for _, group := range s.config.SymbolGroups {
+		if group.MaxNotional > 0 && group.Contains(plan.Symbol) {
+			headroom = math.Min(headroom, group.MaxNotional-e.group(group).Notional)
+		}
+	}
+	return headroom
+}
@@ -0,0 +1,71 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/utils"
+)
+
+// checkFundingBlackout blocks the entry around the funding timestamps of the
+// symbol, the last one is read from the funding rate history
+func (s *service) checkFundingBlackout(plan *entryPlan) error {
+	if s.config.FundingBlackout <= 0 {
+		return nil
+	}
+
+	var lastFunding time.Time
+	rates, err := s.client.NewFundingRateService().Symbol(plan.Symbol).Limit(1).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("Funding blackout: %v", err)
+	}
+	if len(rates) > 0 {
+		lastFunding = time.UnixMilli(rates[0].FundingTime)
+	}
+
+	if blackout, reason := s.calendarService.IsFundingBlackout(time.Now(), lastFunding, plan.NextFundingTime); blackout {
+		return fmt.Errorf("Blackout: new entries are blocked, %s", reason)
+	}
+	return nil
+}
+
+// checkFunding blocks or shrinks the entry when the funding rate works against
+// the position beyond the threshold and the next funding is close
+func (s *service) checkFunding(plan *entryPlan) error {
+	if s.config.FundingRateThreshold <= 0 || plan.NextFundingTime.IsZero() {
+		return nil
+	}
+
+	// Longs pay when the rate is positive, shorts when it's negative
+	adverseRate := plan.FundingRate * 100
+	if plan.Side == futures.PositionSideTypeShort {
+		adverseRate = -adverseRate
+	}
+	if adverseRate <= s.config.FundingRateThreshold {
+		return nil
+	}
+
+	untilFunding := time.Until(plan.NextFundingTime)
+	if s.config.FundingWindow > 0 && untilFunding > s.config.FundingWindow {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Funding: %s [%s] pays %.4f%% in %s, max %.4f%%",
+		plan.Symbol, plan.Side, adverseRate, untilFunding.Round(time.Minute), s.config.FundingRateThreshold)
+
+	if s.config.FundingAction != constants.FundingActionShrink {
+		return fmt.Errorf("%s", reason)
+	}
+
+	quantity := utils.Floor(plan.Quantity*s.config.FundingShrinkFactor, plan.QuantityPrecision)
+	if quantity <= 0 {
+		return fmt.Errorf("%s", reason)
+	}
+	plan.Quantity = quantity
+	plan.Notes = append(plan.Notes, fmt.Sprintf("%s, shrink x%.2f", reason, s.config.FundingShrinkFactor))
+	return nil
+}
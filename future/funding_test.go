@@ -0,0 +1,60 @@
+package future
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+)
+
+func TestCheckFunding(t *testing.T) {
+	config := &models.EnvConfig{
+		FundingRateThreshold: 0.1,
+		FundingWindow:        30 * time.Minute,
+		FundingAction:        constants.FundingActionShrink,
+		FundingShrinkFactor:  0.5,
+	}
+	soon := time.Now().Add(10 * time.Minute)
+
+	tests := []struct {
+		name            string
+		side            futures.PositionSideType
+		fundingRate     float64
+		nextFundingTime time.Time
+		quantity        float64
+		want            float64
+		wantErr         bool
+	}{
+		{"long pays, shrunk", futures.PositionSideTypeLong, 0.002, soon, 0.1, 0.05, false},
+		{"short receives", futures.PositionSideTypeShort, 0.002, soon, 0.1, 0.1, false},
+		{"short pays, shrunk", futures.PositionSideTypeShort, -0.002, soon, 0.1, 0.05, false},
+		{"below the threshold", futures.PositionSideTypeLong, 0.0005, soon, 0.1, 0.1, false},
+		{"outside the window", futures.PositionSideTypeLong, 0.002, time.Now().Add(2 * time.Hour), 0.1, 0.1, false},
+		{"no funding time", futures.PositionSideTypeLong, 0.002, time.Time{}, 0.1, 0.1, false},
+		{"shrunk below one lot", futures.PositionSideTypeLong, 0.002, soon, 0.001, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(config)
+			plan := &entryPlan{
+				Symbol:            "BTCUSDT",
+				Side:              tt.side,
+				Quantity:          tt.quantity,
+				QuantityPrecision: 3,
+				FundingRate:       tt.fundingRate,
+				NextFundingTime:   tt.nextFundingTime,
+			}
+			err := s.checkFunding(plan)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkFunding() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && plan.Quantity != tt.want {
+				t.Errorf("checkFunding() quantity = %v, want %v", plan.Quantity, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package future
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+const incomeHistoryLimit = 1000
+
+// Income types of the income history
+const (
+	incomeTypeRealizedPnl = "REALIZED_PNL"
+	incomeTypeCommission  = "COMMISSION"
+	incomeTypeFundingFee  = "FUNDING_FEE"
+)
+
+// listIncome pages through the income history between startTime and endTime,
+// an empty incomeType returns every type
+func (s *service) listIncome(incomeType string, startTime, endTime int64) ([]*futures.IncomeHistory, error) {
+	var result []*futures.IncomeHistory
+	seen := make(map[string]bool)
+
+	for {
+		incomes, err := s.client.NewGetIncomeHistoryService().
+			IncomeType(incomeType).
+			StartTime(startTime).
+			EndTime(endTime).
+			Limit(incomeHistoryLimit).
+			Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		var added int
+		for _, v := range incomes {
+			// Pages overlap on the boundary millisecond
+			key := fmt.Sprintf("%d:%s", v.TranID, v.IncomeType)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, v)
+			added++
+		}
+
+		if len(incomes) < incomeHistoryLimit || added == 0 {
+			break
+		}
+		startTime = incomes[len(incomes)-1].Time
+	}
+
+	return result, nil
+}
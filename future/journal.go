@@ -0,0 +1,96 @@
+package future
+
+import (
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/journal"
+	"tradingview-binance-webhook/models"
+)
+
+// orderRequest is the journaled copy of an order sent to Binance
+type orderRequest struct {
+	Symbol        string                   `json:"symbol"`
+	Side          futures.SideType         `json:"side"`
+	PositionSide  futures.PositionSideType `json:"position_side"`
+	Type          futures.OrderType        `json:"type"`
+	Quantity      string                   `json:"quantity,omitempty"`
+	Price         string                   `json:"price,omitempty"`
+	StopPrice     string                   `json:"stop_price,omitempty"`
+	ClosePosition bool                     `json:"close_position,omitempty"`
+	ClientOrderID string                   `json:"client_order_id,omitempty"`
+}
+
+// journalDecision records the pre-trade decision on the command
+func (s *service) journalDecision(command *models.Command, outcome, reason string, data interface{}) {
+	s.journal.Record(models.JournalEntry{
+		CorrelationID: command.CorrelationID,
+		Type:          constants.JournalTypeDecision,
+		Symbol:        command.Symbol,
+		Side:          string(command.Side),
+		Strategy:      command.Strategy,
+		Outcome:       outcome,
+		Reason:        reason,
+		Data:          data,
+	})
+}
+
+// journalOrder records the order request with the response or the error, in
+// the journal and in the audit log
+func (s *service) journalOrder(request orderRequest, response *futures.CreateOrderResponse, err error) {
+	correlationID, _, _ := journal.ParseClientOrderID(request.ClientOrderID)
+	entry := models.JournalEntry{
+		CorrelationID: correlationID,
+		Type:          constants.JournalTypeOrder,
+		Symbol:        request.Symbol,
+		Side:          string(request.PositionSide),
+		Outcome:       constants.JournalOutcomePlaced,
+		Data: map[string]interface{}{
+			"request":  request,
+			"response": response,
+		},
+	}
+	if err != nil {
+		entry.Outcome = constants.JournalOutcomeFailed
+		entry.Reason = err.Error()
+	}
+	s.journal.Record(entry)
+
+	details := map[string]interface{}{
+		"request": request,
+		"outcome": entry.Outcome,
+	}
+	if response != nil {
+		details["order_id"] = response.OrderID
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	s.audit.Record("bot", constants.AuditActionOrder, details)
+}
+
+// journalFill records a fill from the user data stream
+func (s *service) journalFill(o futures.WsOrderTradeUpdate) {
+	if o.ExecutionType != futures.OrderExecutionTypeTrade {
+		return
+	}
+
+	correlationID, _, _ := journal.ParseClientOrderID(o.ClientOrderID)
+	entry := models.JournalEntry{
+		CorrelationID: correlationID,
+		Type:          constants.JournalTypeFill,
+		Symbol:        o.Symbol,
+		Side:          string(o.PositionSide),
+		Outcome:       string(o.Status),
+		Data:          o,
+	}
+	if f, err := strconv.ParseFloat(o.RealizedPnL, 64); err == nil {
+		entry.RealizedPnl = f
+	}
+	if orderBook, ok := s.store.GetOrderBook(cooldownKey(o.Symbol, o.PositionSide)); ok {
+		entry.Strategy = orderBook.Strategy
+	}
+	s.journal.Record(entry)
+}
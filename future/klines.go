@@ -0,0 +1,136 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+type klinesEntry struct {
+	klines    []*futures.Kline
+	expiresAt time.Time
+}
+
+// klinesCache keeps the recent klines per symbol and interval until the next kline closes
+type klinesCache struct {
+	mu      sync.Mutex
+	entries map[string]*klinesEntry
+}
+
+func newKlinesCache() *klinesCache {
+	return &klinesCache{
+		entries: make(map[string]*klinesEntry),
+	}
+}
+
+// intervalDuration converts a Binance kline interval such as 15m, 4h or 1d
+func intervalDuration(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("invalid interval: %s", interval)
+	}
+
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval: %s", interval)
+	}
+
+	switch interval[len(interval)-1:] {
+	case "m":
+		return time.Duration(n) * time.Minute, nil
+	case "h":
+		return time.Duration(n) * time.Hour, nil
+	case "d":
+		return time.Duration(n) * 24 * time.Hour, nil
+	case "w":
+		return time.Duration(n) * 7 * 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("invalid interval: %s", interval)
+}
+
+// getKlines returns the last limit closed klines of symbol
+func (s *service) getKlines(symbol, interval string, limit int) ([]*futures.Kline, error) {
+	key := strings.Join([]string{symbol, interval, strconv.Itoa(limit)}, ":")
+
+	s.klines.mu.Lock()
+	defer s.klines.mu.Unlock()
+
+	if e, ok := s.klines.entries[key]; ok && time.Now().Before(e.expiresAt) {
+		return e.klines, nil
+	}
+
+	duration, err := intervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	// One more for the kline that is still open
+	klines, err := s.client.NewKlinesService().
+		Symbol(symbol).
+		Interval(interval).
+		Limit(limit + 1).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if len(klines) == 0 {
+		return nil, fmt.Errorf("no klines for %s %s", symbol, interval)
+	}
+
+	last := klines[len(klines)-1]
+	if last.CloseTime > time.Now().UnixMilli() {
+		klines = klines[:len(klines)-1]
+	}
+
+	s.klines.entries[key] = &klinesEntry{
+		klines:    klines,
+		expiresAt: time.UnixMilli(last.OpenTime).Add(duration),
+	}
+	return klines, nil
+}
+
+// calculateATR returns the average true range of the last period closed klines
+func (s *service) calculateATR(symbol, interval string, period int) (float64, error) {
+	klines, err := s.getKlines(symbol, interval, period+1)
+	if err != nil {
+		return 0, err
+	}
+	if len(klines) < period+1 {
+		return 0, fmt.Errorf("ATR: %d klines for %s %s, need %d", len(klines), symbol, interval, period+1)
+	}
+	return averageTrueRange(klines[len(klines)-period-1:])
+}
+
+// averageTrueRange returns the simple average of the true ranges of klines,
+// the first kline only provides the previous close
+func averageTrueRange(klines []*futures.Kline) (float64, error) {
+	if len(klines) < 2 {
+		return 0, fmt.Errorf("ATR: %d klines, need at least 2", len(klines))
+	}
+
+	var sum float64
+	for i := 1; i < len(klines); i++ {
+		high, err := strconv.ParseFloat(klines[i].High, 64)
+		if err != nil {
+			return 0, err
+		}
+		low, err := strconv.ParseFloat(klines[i].Low, 64)
+		if err != nil {
+			return 0, err
+		}
+		prevClose, err := strconv.ParseFloat(klines[i-1].Close, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		trueRange := math.Max(high-low, math.Max(math.Abs(high-prevClose), math.Abs(low-prevClose)))
+		sum += trueRange
+	}
+
+	return sum / float64(len(klines)-1), nil
+}
@@ -0,0 +1,113 @@
+package future
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+)
+
+// kline builds a kline from its high, low and close
+func kline(high, low, close string) *futures.Kline {
+	return &futures.Kline{High: high, Low: low, Close: close}
+}
+
+func TestAverageTrueRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		klines  []*futures.Kline
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:   "high low range",
+			klines: []*futures.Kline{kline("101", "99", "100"), kline("105", "98", "102"), kline("103", "101", "101")},
+			want:   4.5,
+		},
+		{
+			name:   "gap up uses the previous close",
+			klines: []*futures.Kline{kline("101", "99", "100"), kline("110", "108", "109")},
+			want:   10,
+		},
+		{
+			name:   "gap down uses the previous close",
+			klines: []*futures.Kline{kline("101", "99", "100"), kline("92", "90", "91")},
+			want:   10,
+		},
+		{
+			name:    "single kline",
+			klines:  []*futures.Kline{kline("101", "99", "100")},
+			wantErr: true,
+		},
+		{
+			name:    "invalid price",
+			klines:  []*futures.Kline{kline("101", "99", "100"), kline("x", "98", "102")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := averageTrueRange(tt.klines)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("averageTrueRange() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("averageTrueRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopTakeProfitPrices(t *testing.T) {
+	tests := []struct {
+		name           string
+		side           futures.PositionSideType
+		mode           string
+		config         models.EnvConfig
+		wantStopLoss   float64
+		wantTakeProfit float64
+		wantErr        bool
+	}{
+		{
+			name:           "percent long",
+			side:           futures.PositionSideTypeLong,
+			mode:           constants.TpSlModePercent,
+			config:         models.EnvConfig{StopLossPercentage: 2, TakeProfitPercentage: 4},
+			wantStopLoss:   98,
+			wantTakeProfit: 104,
+		},
+		{
+			name:           "percent short",
+			side:           futures.PositionSideTypeShort,
+			mode:           constants.TpSlModePercent,
+			config:         models.EnvConfig{StopLossPercentage: 2, TakeProfitPercentage: 4},
+			wantStopLoss:   102,
+			wantTakeProfit: 96,
+		},
+		{
+			name:    "atr without multipliers",
+			side:    futures.PositionSideTypeLong,
+			mode:    constants.TpSlModeATR,
+			config:  models.EnvConfig{ATRStopLossMultiplier: 0, ATRTakeProfitMultiplier: 3},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			s := &service{config: &config}
+			stopLoss, takeProfit, err := s.stopTakeProfitPrices("BTCUSDT", tt.side, tt.mode, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stopTakeProfitPrices() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if math.Abs(stopLoss-tt.wantStopLoss) > 1e-9 || math.Abs(takeProfit-tt.wantTakeProfit) > 1e-9 {
+				t.Errorf("stopTakeProfitPrices() = %v, %v, want %v, %v", stopLoss, takeProfit, tt.wantStopLoss, tt.wantTakeProfit)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package future
+
+import (
+	"sync"
+	"time"
+
+	"tradingview-binance-webhook/models"
+)
+
+// latencyStats collects the alert-to-execution latency for the daily report
+type latencyStats struct {
+	mu    sync.Mutex
+	count int
+	total time.Duration
+	max   time.Duration
+}
+
+func (l *latencyStats) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	l.total += d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+// report returns the stats collected since the last reset
+func (l *latencyStats) report() models.LatencyReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report := models.LatencyReport{
+		Count: l.count,
+		Max:   l.max,
+	}
+	if l.count > 0 {
+		report.Average = l.total / time.Duration(l.count)
+	}
+	return report
+}
+
+// reset starts a new period
+func (l *latencyStats) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count, l.total, l.max = 0, 0, 0
+}
@@ -0,0 +1,53 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+const leverageBracketTTL = 24 * time.Hour
+
+type leverageBracketEntry struct {
+	brackets  []futures.Bracket
+	fetchedAt time.Time
+}
+
+// leverageBracketCache keeps the leverage brackets per symbol, they rarely change
+type leverageBracketCache struct {
+	mu      sync.Mutex
+	entries map[string]*leverageBracketEntry
+}
+
+func newLeverageBracketCache() *leverageBracketCache {
+	return &leverageBracketCache{
+		entries: make(map[string]*leverageBracketEntry),
+	}
+}
+
+// getLeverageBrackets returns the symbol brackets ordered by notional floor
+func (s *service) getLeverageBrackets(symbol string) ([]futures.Bracket, error) {
+	s.leverageBrackets.mu.Lock()
+	defer s.leverageBrackets.mu.Unlock()
+
+	if e, ok := s.leverageBrackets.entries[symbol]; ok && time.Since(e.fetchedAt) < leverageBracketTTL {
+		return e.brackets, nil
+	}
+
+	res, err := s.client.NewGetLeverageBracketService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no leverage bracket for %s", symbol)
+	}
+
+	s.leverageBrackets.entries[symbol] = &leverageBracketEntry{
+		brackets:  res[0].Brackets,
+		fetchedAt: time.Now(),
+	}
+	return res[0].Brackets, nil
+}
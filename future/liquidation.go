@@ -0,0 +1,121 @@
+package future
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+)
+
+// estimateLiquidationPrice estimates the isolated liquidation price of a
+// position with quantity, entry price and wallet balance
+func estimateLiquidationPrice(side futures.PositionSideType, quantity, entryPrice, wallet, mmr, cum float64) float64 {
+	if side == futures.PositionSideTypeShort {
+		return (wallet + cum + quantity*entryPrice) / (quantity*mmr + quantity)
+	}
+	return math.Max((wallet+cum-quantity*entryPrice)/(quantity*mmr-quantity), 0)
+}
+
+// liquidationBuffer returns how far the liquidation price lies beyond the stop
+// in percent of the entry price, negative when the stop is past liquidation
+func liquidationBuffer(side futures.PositionSideType, stopLoss, liquidationPrice, entryPrice float64) float64 {
+	if side == futures.PositionSideTypeShort {
+		return (liquidationPrice - stopLoss) / entryPrice * 100
+	}
+	return (stopLoss - liquidationPrice) / entryPrice * 100
+}
+
+// checkLiquidation estimates the liquidation price of the pending position and
+// rejects the entry when the stop lies past it, or picks the highest leverage
+// that keeps the liquidation price a safe buffer beyond the stop
+func (s *service) checkLiquidation(plan *entryPlan, positionRisk *futures.PositionRisk) error {
+	if s.config.LiquidationGuard == "" {
+		return nil
+	}
+	entryPrice := plan.EntryPrice()
+
+	// Merge with the position we are adding to
+	var quantity, wallet float64
+	if positionRisk != nil {
+		if f, err := strconv.ParseFloat(positionRisk.PositionAmt, 64); err == nil {
+			quantity = math.Abs(f)
+		}
+		if f, err := strconv.ParseFloat(positionRisk.IsolatedWallet, 64); err == nil {
+			wallet = f
+		}
+		if f, err := strconv.ParseFloat(positionRisk.EntryPrice, 64); err == nil && quantity > 0 {
+			entryPrice = (f*quantity + entryPrice*plan.Quantity) / (quantity + plan.Quantity)
+		}
+		if l, err := strconv.Atoi(positionRisk.Leverage); err == nil && quantity > 0 {
+			// The leverage of an open isolated position can't be freely changed
+			plan.Leverage = l
+		}
+	}
+	totalQuantity := quantity + plan.Quantity
+	notional := totalQuantity * entryPrice
+
+	mmr, cum, err := s.maintMarginRate(plan.Symbol, notional)
+	if err != nil {
+		return err
+	}
+
+	stopLoss, _, err := s.stopTakeProfitPrices(plan.Symbol, plan.Side, plan.TpSlMode, entryPrice)
+	if err != nil {
+		return err
+	}
+
+	estimate := func(leverage int) (float64, float64) {
+		liquidationPrice := estimateLiquidationPrice(plan.Side, totalQuantity, entryPrice, wallet+plan.Notional()/float64(leverage), mmr, cum)
+		return liquidationPrice, liquidationBuffer(plan.Side, stopLoss, liquidationPrice, entryPrice)
+	}
+
+	if s.config.LiquidationGuard == constants.LiquidationGuardAutoLeverage && quantity == 0 {
+		maxLeverage, err := s.maxInitialLeverage(plan.Symbol, notional)
+		if err != nil {
+			return err
+		}
+		if s.config.MaxLeverage > 0 && s.config.MaxLeverage < maxLeverage {
+			maxLeverage = s.config.MaxLeverage
+		}
+
+		for leverage := maxLeverage; leverage >= 1; leverage-- {
+			if liquidationPrice, buffer := estimate(leverage); buffer >= s.config.LiquidationBufferPercentage {
+				fmt.Printf("Liquidation: %s [%s] pick leverage %d, Stop Loss: %f, Liquidation Price: %f, Buffer: %.2f%%\n",
+					plan.Symbol, plan.Side, leverage, stopLoss, liquidationPrice, buffer)
+				plan.Leverage = leverage
+				return nil
+			}
+		}
+
+		return fmt.Errorf("Liquidation: no leverage keeps %.2f%% between stop %f and liquidation on %s", s.config.LiquidationBufferPercentage, stopLoss, plan.Symbol)
+	}
+
+	liquidationPrice, buffer := estimate(plan.Leverage)
+	if buffer < s.config.LiquidationBufferPercentage {
+		return fmt.Errorf("Liquidation: stop %f is %.2f%% from liquidation %f at leverage %d, min %.2f%%",
+			stopLoss, buffer, liquidationPrice, plan.Leverage, s.config.LiquidationBufferPercentage)
+	}
+
+	return nil
+}
+
+// maxInitialLeverage returns the highest leverage the bracket of notional allows
+func (s *service) maxInitialLeverage(symbol string, notional float64) (int, error) {
+	brackets, err := s.getLeverageBrackets(symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, b := range brackets {
+		if notional >= b.NotionalFloor && notional < b.NotionalCap {
+			return b.InitialLeverage, nil
+		}
+	}
+	if len(brackets) > 0 {
+		return brackets[len(brackets)-1].InitialLeverage, nil
+	}
+	return 0, fmt.Errorf("no leverage bracket for %s", symbol)
+}
@@ -0,0 +1,61 @@
+package future
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+func TestEstimateLiquidationPrice(t *testing.T) {
+	tests := []struct {
+		name       string
+		side       futures.PositionSideType
+		quantity   float64
+		entryPrice float64
+		wallet     float64
+		mmr        float64
+		cum        float64
+		want       float64
+	}{
+		{"long 10x", futures.PositionSideTypeLong, 1, 100, 10, 0.004, 0, 90.36144578313252},
+		{"short 10x", futures.PositionSideTypeShort, 1, 100, 10, 0.004, 0, 109.56175298804781},
+		{"long with maintenance amount", futures.PositionSideTypeLong, 2, 50000, 10000, 0.005, 50, 45201.005025125625},
+		{"short with maintenance amount", futures.PositionSideTypeShort, 2, 50000, 10000, 0.005, 50, 54751.243781094534},
+		{"long over collateralized", futures.PositionSideTypeLong, 1, 100, 200, 0.004, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := estimateLiquidationPrice(tt.side, tt.quantity, tt.entryPrice, tt.wallet, tt.mmr, tt.cum)
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("estimateLiquidationPrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiquidationBuffer(t *testing.T) {
+	tests := []struct {
+		name             string
+		side             futures.PositionSideType
+		stopLoss         float64
+		liquidationPrice float64
+		entryPrice       float64
+		want             float64
+	}{
+		{"long stop before liquidation", futures.PositionSideTypeLong, 95, 90, 100, 5},
+		{"long stop past liquidation", futures.PositionSideTypeLong, 88, 90, 100, -2},
+		{"short stop before liquidation", futures.PositionSideTypeShort, 105, 110, 100, 5},
+		{"short stop past liquidation", futures.PositionSideTypeShort, 112, 110, 100, -2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := liquidationBuffer(tt.side, tt.stopLoss, tt.liquidationPrice, tt.entryPrice)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("liquidationBuffer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/utils"
+)
+
+// accountMargin is the part of the futures account the margin guard needs
+type accountMargin struct {
+	AvailableBalance  float64
+	MarginBalance     float64
+	MaintenanceMargin float64
+}
+
+// MarginRatio returns the account margin ratio in percent
+func (a *accountMargin) MarginRatio() float64 {
+	if a.MarginBalance <= 0 {
+		return 0
+	}
+	return a.MaintenanceMargin / a.MarginBalance * 100
+}
+
+func (s *service) getAccountMargin() (*accountMargin, error) {
+	account, err := s.client.NewGetAccountService().Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := s.client.NewGetBalanceService().Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	margin := &accountMargin{}
+	if f, err := strconv.ParseFloat(account.TotalMarginBalance, 64); err == nil {
+		margin.MarginBalance = f
+	}
+	if f, err := strconv.ParseFloat(account.TotalMaintMargin, 64); err == nil {
+		margin.MaintenanceMargin = f
+	}
+	for _, b := range balances {
+		if b.Asset == s.config.MarginAsset {
+			if f, err := strconv.ParseFloat(b.AvailableBalance, 64); err == nil {
+				margin.AvailableBalance = f
+			}
+			break
+		}
+	}
+
+	return margin, nil
+}
+
+// maintMarginRate returns the maintenance margin rate of the bracket the
+// notional falls in, and the maintenance amount of that bracket
+func (s *service) maintMarginRate(symbol string, notional float64) (float64, float64, error) {
+	brackets, err := s.getLeverageBrackets(symbol)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, b := range brackets {
+		if notional >= b.NotionalFloor && notional < b.NotionalCap {
+			return b.MaintMarginRatio, b.Cum, nil
+		}
+	}
+	if len(brackets) > 0 {
+		last := brackets[len(brackets)-1]
+		return last.MaintMarginRatio, last.Cum, nil
+	}
+	return 0, 0, fmt.Errorf("no leverage bracket for %s", symbol)
+}
+
+// checkMargin rejects or shrinks the entry when its initial margin exceeds the
+// available balance or the post-trade margin ratio would cross the ceiling
+func (s *service) checkMargin(plan *entryPlan) error {
+	if !s.config.MarginGuard {
+		return nil
+	}
+
+	margin, err := s.getAccountMargin()
+	if err != nil {
+		return err
+	}
+
+	mmr, cum, err := s.maintMarginRate(plan.Symbol, plan.Notional())
+	if err != nil {
+		return err
+	}
+
+	// Largest notional the account can afford
+	maxNotional := margin.AvailableBalance * float64(plan.Leverage)
+	if s.config.MaxMarginRatio > 0 && mmr > 0 {
+		// (maint + notional * mmr - cum) / marginBalance <= ceiling
+		headroom := margin.MarginBalance*s.config.MaxMarginRatio/100 - margin.MaintenanceMargin + cum
+		if notional := headroom / mmr; notional < maxNotional {
+			maxNotional = notional
+		}
+	}
+
+	if plan.Notional() <= maxNotional {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Margin: required $%.2f, available $%.2f, margin ratio %.2f%%, max %.2f%%",
+		plan.Margin(), margin.AvailableBalance, margin.MarginRatio(), s.config.MaxMarginRatio)
+
+	if s.config.MarginGuardAction != constants.MarginGuardActionShrink || maxNotional <= 0 {
+		return fmt.Errorf("%s", reason)
+	}
+
+	quantity := utils.Floor(maxNotional/plan.MarkPrice, plan.QuantityPrecision)
+	if quantity <= 0 {
+		return fmt.Errorf("%s", reason)
+	}
+
+	plan.Quantity = quantity
+	fmt.Printf("%s, shrink quantity to %f\n", reason, plan.Quantity)
+	return nil
+}
@@ -0,0 +1,100 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/journal"
+)
+
+// listOpenPositions returns every position with a non zero amount
+func (s *service) listOpenPositions() ([]*futures.PositionRisk, error) {
+	positions, err := s.client.NewGetPositionRiskService().Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*futures.PositionRisk
+	for _, p := range positions {
+		if amount, err := strconv.ParseFloat(p.PositionAmt, 64); err == nil && amount != 0 {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// closePosition cancels the open orders of the position side and closes the
+// position at market
+func (s *service) closePosition(position *futures.PositionRisk) error {
+	amount, err := strconv.ParseFloat(position.PositionAmt, 64)
+	if err != nil {
+		return err
+	}
+	if amount == 0 {
+		return nil
+	}
+
+	side := futures.SideTypeSell
+	if amount < 0 {
+		side = futures.SideTypeBuy
+	}
+
+	// The other side of a hedged symbol keeps its TP and SL
+	orders, err := s.client.NewListOpenOrdersService().Symbol(position.Symbol).Do(context.Background())
+	if err != nil {
+		fmt.Println("ClosePosition ListOpenOrders: ", err)
+	}
+	for _, o := range orders {
+		if string(o.PositionSide) != position.PositionSide {
+			continue
+		}
+		if _, err := s.client.NewCancelOrderService().Symbol(o.Symbol).OrderID(o.OrderID).Do(context.Background()); err != nil {
+			fmt.Println("ClosePosition CancelOrder: ", o.OrderID, err)
+		}
+	}
+
+	request := orderRequest{
+		Symbol:        position.Symbol,
+		Side:          side,
+		PositionSide:  futures.PositionSideType(position.PositionSide),
+		Type:          futures.OrderTypeMarket,
+		Quantity:      strconv.FormatFloat(math.Abs(amount), 'f', -1, 64),
+		ClientOrderID: journal.ClientOrderID(journal.NewCorrelationID(), journal.StepClose),
+	}
+	futureOrder, err := s.client.NewCreateOrderService().
+		Symbol(request.Symbol).
+		Side(request.Side).
+		PositionSide(request.PositionSide).
+		Type(request.Type).
+		Quantity(request.Quantity).
+		NewClientOrderID(request.ClientOrderID).
+		Do(context.Background())
+	s.journalOrder(request, futureOrder, err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Closed position: %+v\n", futureOrder)
+	return nil
+}
+
+// closeAllPositions closes every open position and returns the symbols that failed
+func (s *service) closeAllPositions() ([]string, error) {
+	positions, err := s.listOpenPositions()
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []string
+	for _, p := range positions {
+		if err := s.closePosition(p); err != nil {
+			log.Println("CloseAllPositions: ", p.Symbol, err)
+			failed = append(failed, p.Symbol)
+		}
+	}
+	return failed, nil
+}
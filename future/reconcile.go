@@ -0,0 +1,289 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/journal"
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/utils"
+)
+
+// Kinds of reconciliation issues
+const (
+	issueOrphanOrder       = "orphan order"
+	issueMissingStop       = "missing SL"
+	issueMissingTakeProfit = "missing TP"
+	issueQuantityMismatch  = "quantity mismatch"
+)
+
+// reconcileIssue is a difference between the positions and their protective orders
+type reconcileIssue struct {
+	Kind         string
+	Symbol       string
+	PositionSide futures.PositionSideType
+	Order        *futures.Order // the orphan or mismatched order
+	Detail       string
+}
+
+func (i reconcileIssue) String() string {
+	return fmt.Sprintf("%s [%s] %s: %s", i.Symbol, i.PositionSide, i.Kind, i.Detail)
+}
+
+// isProtectiveOrder returns true for a TP or SL that can only reduce a
+// position, a stop or take-profit entry of the user is left alone
+func isProtectiveOrder(o *futures.Order) bool {
+	if o.Type != futures.OrderTypeStopMarket && o.Type != futures.OrderTypeTakeProfitMarket {
+		return false
+	}
+	return o.ClosePosition || o.ReduceOnly
+}
+
+// positionSideOf returns the side of a position, one-way mode positions are
+// BOTH and take the side of their amount
+func positionSideOf(p *futures.PositionRisk, amount float64) futures.PositionSideType {
+	if futures.PositionSideType(p.PositionSide) != futures.PositionSideTypeBoth {
+		return futures.PositionSideType(p.PositionSide)
+	}
+	if amount < 0 {
+		return futures.PositionSideTypeShort
+	}
+	return futures.PositionSideTypeLong
+}
+
+// findIssues compares the open positions of one symbol with its open orders
+func (s *service) findIssues(symbol string, positions []*futures.PositionRisk, orders []*futures.Order) []reconcileIssue {
+	amounts := make(map[futures.PositionSideType]float64)
+	for _, p := range positions {
+		if p.Symbol != symbol {
+			continue
+		}
+		if amount, err := strconv.ParseFloat(p.PositionAmt, 64); err == nil && amount != 0 {
+			amounts[futures.PositionSideType(p.PositionSide)] = amount
+		}
+	}
+
+	var issues []reconcileIssue
+	hasStop := make(map[futures.PositionSideType]bool)
+	hasTakeProfit := make(map[futures.PositionSideType]bool)
+	for _, o := range orders {
+		if o.Symbol != symbol || !isProtectiveOrder(o) {
+			continue
+		}
+
+		amount, ok := amounts[o.PositionSide]
+		if !ok {
+			issues = append(issues, reconcileIssue{
+				Kind:         issueOrphanOrder,
+				Symbol:       symbol,
+				PositionSide: o.PositionSide,
+				Order:        o,
+				Detail:       fmt.Sprintf("%s #%d at %s without a position", o.Type, o.OrderID, o.StopPrice),
+			})
+			continue
+		}
+
+		if !o.ClosePosition {
+			if quantity, err := strconv.ParseFloat(o.OrigQuantity, 64); err == nil && quantity != math.Abs(amount) {
+				issues = append(issues, reconcileIssue{
+					Kind:         issueQuantityMismatch,
+					Symbol:       symbol,
+					PositionSide: o.PositionSide,
+					Order:        o,
+					Detail:       fmt.Sprintf("%s #%d quantity %s, position %s", o.Type, o.OrderID, o.OrigQuantity, strconv.FormatFloat(math.Abs(amount), 'f', -1, 64)),
+				})
+			}
+		}
+
+		if o.Type == futures.OrderTypeStopMarket {
+			hasStop[o.PositionSide] = true
+		} else {
+			hasTakeProfit[o.PositionSide] = true
+		}
+	}
+
+	for positionSide, amount := range amounts {
+		detail := fmt.Sprintf("position %s", strconv.FormatFloat(amount, 'f', -1, 64))
+		if s.config.ReconcileRequireSL && !hasStop[positionSide] {
+			issues = append(issues, reconcileIssue{Kind: issueMissingStop, Symbol: symbol, PositionSide: positionSide, Detail: detail})
+		}
+		if s.config.ReconcileRequireTP && !hasTakeProfit[positionSide] {
+			issues = append(issues, reconcileIssue{Kind: issueMissingTakeProfit, Symbol: symbol, PositionSide: positionSide, Detail: detail})
+		}
+	}
+
+	return issues
+}
+
+// reconcile compares every position with the open orders, rebuilds the
+// internal state and, with the fix policy, cancels orphan orders and places
+// the missing or mismatched TP and SL. Whatever was found is notified.
+func (s *service) reconcile() error {
+	positions, err := s.client.NewGetPositionRiskService().Do(context.Background())
+	if err != nil {
+		return err
+	}
+	orders, err := s.client.NewListOpenOrdersService().Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// Symbols with an open position or a protective order
+	symbols := make(map[string]bool)
+	for _, p := range positions {
+		if amount, err := strconv.ParseFloat(p.PositionAmt, 64); err == nil && amount != 0 {
+			symbols[p.Symbol] = true
+		}
+	}
+	for _, o := range orders {
+		if isProtectiveOrder(o) {
+			symbols[o.Symbol] = true
+		}
+	}
+
+	s.rebuildState(positions)
+
+	var lines []string
+	for symbol := range symbols {
+		if len(s.findIssues(symbol, positions, orders)) == 0 {
+			continue
+		}
+		lines = append(lines, s.reconcileSymbol(symbol)...)
+	}
+
+	log.Printf("Reconcile: %d symbols, %d open orders, %d issues\n", len(symbols), len(orders), len(lines))
+	if len(lines) > 0 {
+		s.lineService.Notify(fmt.Sprintf("🔍 Reconcile (%s)\n%s", s.config.ReconcilePolicy, strings.Join(lines, "\n")))
+	}
+	return nil
+}
+
+// reconcileSymbol reloads the symbol while holding its lock, so an alert in
+// flight isn't mistaken for an issue, and fixes or reports what's still wrong
+func (s *service) reconcileSymbol(symbol string) []string {
+	unlock := s.store.Lock(symbol)
+	defer unlock()
+
+	positions, err := s.client.NewGetPositionRiskService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", symbol, err)}
+	}
+	orders, err := s.client.NewListOpenOrdersService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", symbol, err)}
+	}
+
+	paused := s.isPaused(symbol)
+	var lines []string
+	for _, issue := range s.findIssues(symbol, positions, orders) {
+		line := "⚠️ " + issue.String()
+		if s.config.ReconcilePolicy == constants.ReconcilePolicyFix {
+			if paused {
+				line += " → not fixed, trading is paused"
+			} else if err := s.fixIssue(issue, positions); err != nil {
+				line += fmt.Sprintf(" → fix failed: %v", err)
+			} else {
+				line += " → fixed"
+			}
+		}
+		log.Println("Reconcile: ", line)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (s *service) fixIssue(issue reconcileIssue, positions []*futures.PositionRisk) error {
+	switch issue.Kind {
+	case issueOrphanOrder:
+		_, err := s.client.NewCancelOrderService().Symbol(issue.Symbol).OrderID(issue.Order.OrderID).Do(context.Background())
+		return err
+
+	case issueQuantityMismatch:
+		// Replace with an order that always closes the whole position
+		if _, err := s.client.NewCancelOrderService().Symbol(issue.Symbol).OrderID(issue.Order.OrderID).Do(context.Background()); err != nil {
+			return err
+		}
+		return s.placeClosePositionOrder(issue.Symbol, issue.Order.Side, issue.PositionSide, issue.Order.Type, issue.Order.StopPrice, s.reconcileClientOrderID(issue.Order.Type == futures.OrderTypeStopMarket))
+
+	case issueMissingStop, issueMissingTakeProfit:
+		var position *futures.PositionRisk
+		for _, p := range positions {
+			if futures.PositionSideType(p.PositionSide) == issue.PositionSide {
+				position = p
+			}
+		}
+		if position == nil {
+			return fmt.Errorf("position not found")
+		}
+		stop := issue.Kind == issueMissingStop
+		mode := s.tpSlMode(&models.Command{Symbol: position.Symbol})
+		return s.protectPosition(position, stop, mode, s.reconcileClientOrderID(stop))
+	}
+	return nil
+}
+
+// protectPosition places the SL, or the TP, of an open position from its entry price
+func (s *service) protectPosition(position *futures.PositionRisk, stop bool, mode, clientOrderID string) error {
+	amount, err := strconv.ParseFloat(position.PositionAmt, 64)
+	if err != nil {
+		return err
+	}
+	entryPrice, err := strconv.ParseFloat(position.EntryPrice, 64)
+	if err != nil {
+		return err
+	}
+
+	side := positionSideOf(position, amount)
+	closeSide := futures.SideTypeSell
+	if side == futures.PositionSideTypeShort {
+		closeSide = futures.SideTypeBuy
+	}
+
+	stopLoss, takeProfit, err := s.stopTakeProfitPrices(position.Symbol, side, mode, entryPrice)
+	if err != nil {
+		return err
+	}
+
+	pricePrecision, _ := s.getDecimalsInfo(position.Symbol)
+	orderType, price := futures.OrderTypeTakeProfitMarket, takeProfit
+	if stop {
+		orderType, price = futures.OrderTypeStopMarket, stopLoss
+	}
+
+	return s.placeClosePositionOrder(position.Symbol, closeSide, futures.PositionSideType(position.PositionSide), orderType, utils.FormatFloat(price, pricePrecision), clientOrderID)
+}
+
+func (s *service) reconcileClientOrderID(stop bool) string {
+	step := journal.StepTakeProfit
+	if stop {
+		step = journal.StepStopLoss
+	}
+	return journal.ClientOrderID(journal.NewCorrelationID(), step)
+}
+
+// rebuildState resets the add-on count and the last entry price of the sides that are flat
+func (s *service) rebuildState(positions []*futures.PositionRisk) {
+	for _, p := range positions {
+		amount, err := strconv.ParseFloat(p.PositionAmt, 64)
+		if err != nil || amount != 0 {
+			continue
+		}
+		key := cooldownKey(p.Symbol, futures.PositionSideType(p.PositionSide))
+		if orderBook, ok := s.store.GetOrderBook(key); ok && (orderBook.Adds > 0 || orderBook.LastPrice > 0) {
+			err := s.store.UpdateOrderBook(key, func(orderBook *models.OrderBook) {
+				orderBook.Adds = 0
+				orderBook.LastPrice = 0
+			})
+			if err != nil {
+				log.Println("Reconcile: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package future
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/models"
+)
+
+func TestIsProtectiveOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order futures.Order
+		want  bool
+	}{
+		{"close position SL", futures.Order{Type: futures.OrderTypeStopMarket, ClosePosition: true}, true},
+		{"close position TP", futures.Order{Type: futures.OrderTypeTakeProfitMarket, ClosePosition: true}, true},
+		{"reduce only SL", futures.Order{Type: futures.OrderTypeStopMarket, ReduceOnly: true}, true},
+		{"reduce only TP", futures.Order{Type: futures.OrderTypeTakeProfitMarket, ReduceOnly: true}, true},
+		{"stop entry", futures.Order{Type: futures.OrderTypeStopMarket}, false},
+		{"take profit entry", futures.Order{Type: futures.OrderTypeTakeProfitMarket}, false},
+		{"reduce only limit", futures.Order{Type: futures.OrderTypeLimit, ReduceOnly: true}, false},
+		{"limit entry", futures.Order{Type: futures.OrderTypeLimit}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isProtectiveOrder(&tt.order); got != tt.want {
+				t.Errorf("isProtectiveOrder() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPositionSideOf(t *testing.T) {
+	tests := []struct {
+		positionSide string
+		amount       float64
+		want         futures.PositionSideType
+	}{
+		{"LONG", 1, futures.PositionSideTypeLong},
+		{"SHORT", -1, futures.PositionSideTypeShort},
+		{"BOTH", 1, futures.PositionSideTypeLong},
+		{"BOTH", -1, futures.PositionSideTypeShort},
+	}
+
+	for _, tt := range tests {
+		if got := positionSideOf(&futures.PositionRisk{PositionSide: tt.positionSide}, tt.amount); got != tt.want {
+			t.Errorf("positionSideOf(%s, %v) = %s, want %s", tt.positionSide, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestFindIssues(t *testing.T) {
+	long := &futures.PositionRisk{Symbol: "BTCUSDT", PositionSide: "LONG", PositionAmt: "0.5"}
+	flatShort := &futures.PositionRisk{Symbol: "BTCUSDT", PositionSide: "SHORT", PositionAmt: "0"}
+	stop := func(positionSide futures.PositionSideType) *futures.Order {
+		return &futures.Order{Symbol: "BTCUSDT", Type: futures.OrderTypeStopMarket, PositionSide: positionSide, ClosePosition: true}
+	}
+	takeProfit := func(positionSide futures.PositionSideType) *futures.Order {
+		return &futures.Order{Symbol: "BTCUSDT", Type: futures.OrderTypeTakeProfitMarket, PositionSide: positionSide, ClosePosition: true}
+	}
+
+	tests := []struct {
+		name      string
+		config    models.EnvConfig
+		positions []*futures.PositionRisk
+		orders    []*futures.Order
+		want      []string
+	}{
+		{
+			name:      "protected",
+			config:    models.EnvConfig{ReconcileRequireSL: true, ReconcileRequireTP: true},
+			positions: []*futures.PositionRisk{long, flatShort},
+			orders:    []*futures.Order{stop(futures.PositionSideTypeLong), takeProfit(futures.PositionSideTypeLong)},
+		},
+		{
+			name:      "missing SL and TP",
+			config:    models.EnvConfig{ReconcileRequireSL: true, ReconcileRequireTP: true},
+			positions: []*futures.PositionRisk{long},
+			want:      []string{issueMissingStop, issueMissingTakeProfit},
+		},
+		{
+			name:      "TP not required",
+			config:    models.EnvConfig{ReconcileRequireSL: true},
+			positions: []*futures.PositionRisk{long},
+			orders:    []*futures.Order{stop(futures.PositionSideTypeLong)},
+		},
+		{
+			name:      "stop entry isn't a SL",
+			config:    models.EnvConfig{ReconcileRequireSL: true},
+			positions: []*futures.PositionRisk{long},
+			orders:    []*futures.Order{{Symbol: "BTCUSDT", Type: futures.OrderTypeStopMarket, PositionSide: futures.PositionSideTypeLong}},
+			want:      []string{issueMissingStop},
+		},
+		{
+			name:      "orphan order of a flat side",
+			config:    models.EnvConfig{ReconcileRequireSL: true},
+			positions: []*futures.PositionRisk{long, flatShort},
+			orders:    []*futures.Order{stop(futures.PositionSideTypeLong), stop(futures.PositionSideTypeShort)},
+			want:      []string{issueOrphanOrder},
+		},
+		{
+			name:      "reduce only quantity mismatch",
+			config:    models.EnvConfig{ReconcileRequireSL: true},
+			positions: []*futures.PositionRisk{long},
+			orders:    []*futures.Order{{Symbol: "BTCUSDT", Type: futures.OrderTypeStopMarket, PositionSide: futures.PositionSideTypeLong, ReduceOnly: true, OrigQuantity: "0.3"}},
+			want:      []string{issueQuantityMismatch},
+		},
+		{
+			name:      "other symbol ignored",
+			config:    models.EnvConfig{ReconcileRequireSL: true},
+			positions: []*futures.PositionRisk{long},
+			orders:    []*futures.Order{stop(futures.PositionSideTypeLong), {Symbol: "ETHUSDT", Type: futures.OrderTypeStopMarket, PositionSide: futures.PositionSideTypeShort, ClosePosition: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			s := &service{config: &config}
+
+			var got []string
+			for _, issue := range s.findIssues("BTCUSDT", tt.positions, tt.orders) {
+				got = append(got, issue.Kind)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("findIssues() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestHasProtectiveOrder(t *testing.T) {
+	orders := []*futures.Order{
+		{Type: futures.OrderTypeStopMarket, PositionSide: futures.PositionSideTypeLong, ReduceOnly: true},
+		{Type: futures.OrderTypeTakeProfitMarket, PositionSide: futures.PositionSideTypeShort},
+	}
+
+	tests := []struct {
+		name         string
+		positionSide futures.PositionSideType
+		orderType    futures.OrderType
+		want         bool
+	}{
+		{"reduce only SL of another workflow", futures.PositionSideTypeLong, futures.OrderTypeStopMarket, true},
+		{"no TP on the side", futures.PositionSideTypeLong, futures.OrderTypeTakeProfitMarket, false},
+		{"take profit entry doesn't count", futures.PositionSideTypeShort, futures.OrderTypeTakeProfitMarket, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasProtectiveOrder(orders, tt.positionSide, tt.orderType); got != tt.want {
+				t.Errorf("hasProtectiveOrder() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
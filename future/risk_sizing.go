@@ -0,0 +1,50 @@
+package future
+
+import (
+	"fmt"
+	"math"
+
+	"tradingview-binance-webhook/utils"
+)
+
+// sizeByRisk sets the quantity so that hitting the stop loses riskPercentage
+// of the account equity, capped by the available margin and the exposure caps
+func (s *service) sizeByRisk(plan *entryPlan, riskPercentage float64, e *exposure) error {
+	margin, err := s.getAccountMargin()
+	if err != nil {
+		return err
+	}
+
+	entryPrice := plan.EntryPrice()
+	stopLoss, _, err := s.stopTakeProfitPrices(plan.Symbol, plan.Side, plan.TpSlMode, entryPrice)
+	if err != nil {
+		return err
+	}
+
+	stopDistance := math.Abs(entryPrice - stopLoss)
+	if stopDistance == 0 {
+		return fmt.Errorf("Risk sizing: stop loss equals entry price on %s", plan.Symbol)
+	}
+
+	equity := margin.MarginBalance
+	quantity := equity * riskPercentage / 100 / stopDistance
+
+	maxNotional := math.Min(margin.AvailableBalance*float64(plan.Leverage), s.notionalHeadroom(plan, e))
+	var capped bool
+	if quantity*entryPrice > maxNotional {
+		quantity = maxNotional / entryPrice
+		capped = true
+	}
+
+	quantity = utils.Floor(quantity, plan.QuantityPrecision)
+	if quantity <= 0 {
+		return fmt.Errorf("Risk sizing: no room for a %s position, equity $%.2f, available $%.2f", plan.Symbol, equity, margin.AvailableBalance)
+	}
+	plan.Quantity = quantity
+	plan.RiskStopLoss = stopLoss
+	plan.RiskEquity = equity
+	plan.RiskCapped = capped
+
+	fmt.Printf("Risk sizing: %s [%s] %s\n", plan.Symbol, plan.Side, plan.RiskNote())
+	return nil
+}
@@ -6,69 +6,229 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/jasonlvhit/gocron"
 
+	"tradingview-binance-webhook/audit"
+	"tradingview-binance-webhook/calendar"
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/journal"
 	"tradingview-binance-webhook/line"
 	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/state"
 	"tradingview-binance-webhook/utils"
 )
 
 type Service interface {
-	Long(command *models.Command) error
-	Short(command *models.Command) error
+	Long(command *models.Command) (*models.CommandResult, error)
+	Short(command *models.Command) (*models.CommandResult, error)
 	GetPositionRisk(command *models.Command) (*futures.PositionRisk, error)
-	CheckPositionRatio(command *models.Command, positionRisk *futures.PositionRisk) (bool, error)
+	SetTradingState(symbol, state string, until time.Time, actor string) error
+	TradingState(symbol string) string
+	GetTradingStates() *models.TradingStates
+	StreamHealth() models.StreamHealth
+	AccountSnapshot() models.AccountSnapshot
 	calculateRealizedPnl() (*models.CalculateRealizedPnl, error)
 
 	startScheduler()
-	listenUserData() error
-
-	tradeSetup(command *models.Command)
-	openOrder(symbol, quantity string, side futures.SideType, positionSide futures.PositionSideType)
+	listenUserData()
+	loadAccountConfig() error
+	loadAccountState() error
+	checkDailyLoss() error
+	resetDailyLoss()
+
+	tradeSetup(command *models.Command, leverage int) error
+	openOrder(symbol, quantity, price string, side futures.SideType, positionSide futures.PositionSideType, clientOrderID string) (float64, error)
+	placeClosePositionOrder(symbol string, side futures.SideType, positionSide futures.PositionSideType, orderType futures.OrderType, stopPrice, clientOrderID string) error
+	checkSlippage(command *models.Command) (*slippageDecision, error)
 	getDecimalsInfo(symbol string) (int, int)
-	calculateQuantity(symbol string, amountUSD int64, quantityPrecision int) float64
-	calculateTpSL(symbol string, side futures.PositionSideType, pricePrecision int) (string, string, error)
+	calculateQuantity(symbol string, amountUSD int64, quantityPrecision int) (float64, *futures.PremiumIndex, error)
+	checkAddOn(plan *entryPlan, positionRisk *futures.PositionRisk) (bool, error)
+	checkFunding(plan *entryPlan) error
+	checkExposure(plan *entryPlan, e *exposure) error
+	checkMargin(plan *entryPlan) error
+	checkLiquidation(plan *entryPlan, positionRisk *futures.PositionRisk) error
+	sizeByRisk(plan *entryPlan, riskPercentage float64, e *exposure) error
+	checkCooldown(command *models.Command) error
+	recordEntry(symbol string, side futures.PositionSideType, isAddOn bool, price float64)
+	recordStopOut(symbol string, side futures.PositionSideType)
+	calculateTpSL(symbol string, side futures.PositionSideType, mode string, pricePrecision int) (string, string, error)
+	stopTakeProfitPrices(symbol string, side futures.PositionSideType, mode string, entryPrice float64) (float64, float64, error)
 	cancelOpenOrders(command models.Command)
+	reconcile() error
 }
 
 type service struct {
-	stateOrderBooks map[string]*models.OrderBook
-	config          *models.EnvConfig
-	client          *futures.Client
-	lineService     line.Service
-	scheduler       *gocron.Scheduler
-	listenKey       string
+	store            state.Store
+	config           *models.EnvConfig
+	client           *futures.Client
+	lineService      line.Service
+	calendarService  calendar.Service
+	journal          journal.Service
+	audit            audit.Service
+	scheduler        *gocron.Scheduler
+	stream           *userStream
+	exposureMu       sync.Mutex
+	accountConfig    *accountConfig
+	account          *accountState
+	latency          *latencyStats
+	circuitBreaker   *circuitBreaker
+	leverageBrackets *leverageBracketCache
+	klines           *klinesCache
+	tradingStates    *tradingStates
+	streaks          *streakTracker
 }
 
 func NewService(
 	config *models.EnvConfig,
-	stateOrderBooks map[string]*models.OrderBook,
+	store state.Store,
 	client *futures.Client,
 	lineService line.Service,
+	calendarService calendar.Service,
+	journalService journal.Service,
+	auditService audit.Service,
 	scheduler *gocron.Scheduler,
 ) Service {
 
 	s := &service{
-		config:          config,
-		stateOrderBooks: stateOrderBooks,
-		client:          client,
-		lineService:     lineService,
-		scheduler:       scheduler,
+		config:           config,
+		store:            store,
+		client:           client,
+		lineService:      lineService,
+		calendarService:  calendarService,
+		journal:          journalService,
+		audit:            auditService,
+		scheduler:        scheduler,
+		accountConfig:    newAccountConfig(),
+		account:          newAccountState(),
+		latency:          &latencyStats{},
+		circuitBreaker:   &circuitBreaker{},
+		leverageBrackets: newLeverageBracketCache(),
+		klines:           newKlinesCache(),
+		tradingStates:    newTradingStates(store, config.TradingStateFile),
+		streaks:          newStreakTracker(),
+		stream:           newUserStream(),
+	}
+
+	// Account Config
+	if err := s.loadAccountConfig(); err != nil {
+		log.Println("LoadAccountConfig: ", err)
+	}
+	if err := s.loadAccountState(); err != nil {
+		log.Println("LoadAccountState: ", err)
+	}
+
+	// Entries interrupted by a crash, then the reconciliation of the positions and their TP/SL
+	s.recoverWorkflows()
+	if err := s.reconcile(); err != nil {
+		log.Println("Reconcile: ", err)
 	}
 
 	// Scheduler
 	go s.startScheduler()
 
-	// Web Socket
+	// Web Socket, reconnects until the process exits
 	go s.listenUserData()
 
 	return s
 }
 
-func (s *service) Long(command *models.Command) error {
+func (s *service) Long(command *models.Command) (*models.CommandResult, error) {
+	return s.execute(command, futures.SideTypeBuy, futures.SideTypeSell)
+}
+
+func (s *service) Short(command *models.Command) (*models.CommandResult, error) {
+	return s.execute(command, futures.SideTypeSell, futures.SideTypeBuy)
+}
+
+// execute rejects stale alerts, runs the entry and records the alert latency
+func (s *service) execute(command *models.Command, side, closeSide futures.SideType) (result *models.CommandResult, err error) {
+
+	if command.CorrelationID == "" {
+		command.CorrelationID = journal.NewCorrelationID()
+	}
+
+	// Alerts of one symbol are processed one after the other
+	unlock := s.store.Lock(command.Symbol)
+	defer unlock()
+
+	// Trading state
+	if state := s.TradingState(command.Symbol); state != constants.TradingStateRunning {
+		err := fmt.Errorf("Trading is %s for %s", state, command.Symbol)
+		s.journalDecision(command, constants.JournalOutcomeRejected, err.Error(), nil)
+		return nil, err
+	}
+
+	// Duplicate alert, the id is kept only once the entry order went through
+	// so a resend of a rejected or failed alert is accepted
+	if command.AlertID != "" {
+		processed, markErr := s.store.MarkAlertProcessed(command.AlertID)
+		if markErr != nil {
+			return nil, markErr
+		}
+		if processed {
+			err := fmt.Errorf("Duplicate alert: %s was already processed", command.AlertID)
+			s.journalDecision(command, constants.JournalOutcomeRejected, err.Error(), nil)
+			return nil, err
+		}
+		defer func() {
+			if err == nil {
+				return
+			}
+			if unmarkErr := s.store.UnmarkAlertProcessed(command.AlertID); unmarkErr != nil {
+				log.Println("UnmarkAlertProcessed: ", unmarkErr)
+			}
+		}()
+	}
+
+	// Stale alert
+	if !command.AlertTime.IsZero() && s.config.MaxAlertAge > 0 {
+		if age := time.Since(command.AlertTime); age > s.config.MaxAlertAge {
+			err := fmt.Errorf("Stale alert: age %s exceeds %s", age.Round(time.Second), s.config.MaxAlertAge)
+			log.Println(err)
+			s.lineService.Notify(fmt.Sprintf("%s [%s] ⏰ %s", command.Symbol, command.Side, err))
+			s.journalDecision(command, constants.JournalOutcomeRejected, err.Error(), nil)
+			return nil, err
+		}
+	}
+
+	if err := s.openPosition(command, side, closeSide); err != nil {
+		return nil, err
+	}
+
+	result = &models.CommandResult{
+		Symbol: command.Symbol,
+		Side:   command.Side,
+	}
+	if !command.ReceivedAt.IsZero() {
+		result.ProcessingTimeMs = time.Since(command.ReceivedAt).Milliseconds()
+	}
+	if !command.AlertTime.IsZero() {
+		alertLatency := time.Since(command.AlertTime)
+		result.AlertLatencyMs = alertLatency.Milliseconds()
+		s.latency.record(alertLatency)
+	}
+
+	log.Printf("Executed: %s [%s], Alert Latency: %dms, Processing Time: %dms\n", result.Symbol, result.Side, result.AlertLatencyMs, result.ProcessingTimeMs)
+	return result, nil
+}
+
+// openPosition is the entry path shared by Long and Short, side opens the
+// position and closeSide is used by the TP and SL orders. An error means no
+// entry was made, a TP or SL failure after the entry is retried instead.
+func (s *service) openPosition(command *models.Command, side, closeSide futures.SideType) (err error) {
+
+	// Journal the rejection reason of the pre-trade checks
+	var decided bool
+	defer func() {
+		if err != nil && !decided {
+			s.journalDecision(command, constants.JournalOutcomeRejected, err.Error(), nil)
+		}
+	}()
 
 	// Check Whitelist
 	var isFoundTokenWL bool
@@ -79,7 +239,6 @@ func (s *service) Long(command *models.Command) error {
 				break
 			}
 		}
-
 	}
 
 	// Check Token Whitelist
@@ -87,215 +246,244 @@ func (s *service) Long(command *models.Command) error {
 		return errors.New("Not found in whlitelist token")
 	}
 
-	// Delay open order
-	if s.isDelayOpenOrder(command) {
-		log.Println("Delay open order")
-		return errors.New("Delay open order")
+	// Trading sessions and blackout calendar
+	if blackout, reason := s.calendarService.IsBlackout(time.Now()); blackout {
+		err := fmt.Errorf("Blackout: new entries are blocked, %s", reason)
+		log.Println(err)
+		return err
 	}
 
-	// GetPositionRisk
-	positionRisk, err := s.GetPositionRisk(command)
-	if err != nil {
+	// Daily loss limit
+	if err := s.checkDailyLoss(); err != nil {
+		log.Println(err)
 		return err
 	}
 
-	var isolatedWallet float64
-	if f, err := strconv.ParseFloat(positionRisk.IsolatedWallet, 64); err == nil {
-		isolatedWallet = f
+	// Cooldown
+	if err := s.checkCooldown(command); err != nil {
+		log.Println(err)
+		return err
 	}
 
-	// If Large position and loss ratio more than config
-	if isolatedWallet > s.config.LimitMarginSize {
-		if canOpenOrder, err := s.CheckPositionRatio(command, positionRisk); !canOpenOrder {
-			log.Println(err)
-			return err
-		}
+	// GetPositionRisk
+	positionRisk, err := s.GetPositionRisk(command)
+	if err != nil {
+		return err
 	}
 
-	// Setup
-	s.tradeSetup(command)
+	// Slippage guard
+	slippage, err := s.checkSlippage(command)
+	if err != nil {
+		log.Println(err)
+		s.lineService.Notify(fmt.Sprintf("%s [%s] ⛔ %s", command.Symbol, command.Side, err))
+		return err
+	}
 
 	// GetDecimalsInfo
 	pricePrecision, quantityPrecision := s.getDecimalsInfo(command.Symbol)
 
-	quantity := s.calculateQuantity(
+	quantity, premiumIndex, err := s.calculateQuantity(
 		command.Symbol,
 		command.AmountUSD,
 		quantityPrecision,
 	)
+	if err != nil {
+		return err
+	}
 
-	// Open Order
-	s.openOrder(command.Symbol, fmt.Sprintf("%.0f", quantity), futures.SideTypeBuy, futures.PositionSideTypeLong)
+	var markPrice, fundingRate float64
+	if f, err := strconv.ParseFloat(premiumIndex.MarkPrice, 64); err == nil {
+		markPrice = f
+	}
+	if f, err := strconv.ParseFloat(premiumIndex.LastFundingRate, 64); err == nil {
+		fundingRate = f
+	}
 
-	// Check is Enable SL or TP
-	if !command.IsSL && !command.IsTP {
-		return nil
+	plan := &entryPlan{
+		Symbol:            command.Symbol,
+		Side:              command.Side,
+		Quantity:          quantity,
+		MarkPrice:         markPrice,
+		FundingRate:       fundingRate,
+		LimitPrice:        slippage.LimitPrice,
+		Leverage:          s.config.Leverage,
+		PricePrecision:    pricePrecision,
+		QuantityPrecision: quantityPrecision,
+		TpSlMode:          s.tpSlMode(command),
+	}
+	// The next funding time is 0 when the symbol has no funding scheduled
+	if premiumIndex.NextFundingTime > 0 {
+		plan.NextFundingTime = time.UnixMilli(premiumIndex.NextFundingTime)
 	}
 
-	// Calcualte TP and SL
-	stopLoss, takeProfit, err := s.calculateTpSL(command.Symbol, futures.PositionSideTypeLong, pricePrecision)
+	// Funding blackout
+	if err := s.checkFundingBlackout(plan); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	// Held until the entry order is sent
+	unlockExposure := s.lockExposure()
+	defer unlockExposure()
+
+	positions, err := s.listOpenPositions()
 	if err != nil {
 		return err
 	}
+	exposure := newExposure(positions)
 
-	// Enable TakeProfit
-	if command.IsTP {
-		futureOrder, err := s.client.NewCreateOrderService().
-			Symbol(command.Symbol).
-			Side(futures.SideTypeSell).
-			PositionSide(futures.PositionSideTypeLong).
-			Type(futures.OrderTypeTakeProfitMarket).
-			StopPrice(takeProfit).
-			ClosePosition(true).
-			TimeInForce(futures.TimeInForceTypeGTC).
-			WorkingType(futures.WorkingTypeMarkPrice).
-			PriceProtect(true).
-			Do(context.Background())
-		if err != nil {
-			fmt.Println("Long TP: ", err, ", TP: ", takeProfit)
-			return nil
+	// Risk per trade sizing
+	if command.RiskPercentage > 0 {
+		if err := s.sizeByRisk(plan, command.RiskPercentage, exposure); err != nil {
+			log.Println(err)
+			return err
 		}
-		fmt.Printf("Enable take profit: %s\n", takeProfit)
-		fmt.Printf("%+v\n", futureOrder)
 	}
-
-	// Enable Stop Loss
-	if command.IsSL {
-		futureOrder, err := s.client.NewCreateOrderService().
-			Symbol(command.Symbol).
-			Side(futures.SideTypeSell).
-			PositionSide(futures.PositionSideTypeLong).
-			Type(futures.OrderTypeStopMarket).
-			StopPrice(stopLoss).
-			ClosePosition(true).
-			TimeInForce(futures.TimeInForceTypeGTC).
-			WorkingType(futures.WorkingTypeMarkPrice).
-			PriceProtect(true).
-			Do(context.Background())
-		if err != nil {
-			fmt.Println("Long SL: ", err)
-			return nil
+	if err := s.applyStreak(plan, command.Strategy); err != nil {
+		log.Println(err)
+		return err
+	}
+	if slippage.SizeFactor != 1 {
+		plan.Quantity = utils.Floor(plan.Quantity*slippage.SizeFactor, quantityPrecision)
+		if plan.Quantity <= 0 {
+			err := fmt.Errorf("Slippage: downsized x%.2f, %s is below one lot", slippage.SizeFactor, command.Symbol)
+			log.Println(err)
+			s.lineService.Notify(fmt.Sprintf("%s [%s] ⛔ %s", command.Symbol, command.Side, err))
+			return err
 		}
-		fmt.Printf("Enable stop loss: %s\n", stopLoss)
-		fmt.Printf("%+v\n", futureOrder)
 	}
 
-	return nil
-}
-
-func (s *service) Short(command *models.Command) error {
+	// Add-on policy
+	isAddOn, err := s.checkAddOn(plan, positionRisk)
+	if err != nil {
+		return err
+	}
 
-	// Check Whitelist
-	var isFoundTokenWL bool
-	if command.IsCheckWL {
-		for i := range s.config.TokenWhitelist {
-			if s.config.TokenWhitelist[i] == command.Symbol {
-				isFoundTokenWL = true
-				break
-			}
-		}
+	// Funding rate filter
+	if err := s.checkFunding(plan); err != nil {
+		log.Println(err)
+		return err
 	}
 
-	// Check Token Whitelist
-	if !isFoundTokenWL && command.IsCheckWL {
-		return errors.New("Not found in whlitelist token")
+	// Exposure caps
+	if err := s.checkExposure(plan, exposure); err != nil {
+		log.Println(err)
+		return err
 	}
 
-	// Delay open order
-	if s.isDelayOpenOrder(command) {
-		log.Println("Delay open order")
-		return errors.New("Delay open order")
+	// Liquidation guard
+	if command.IsSL {
+		if err := s.checkLiquidation(plan, positionRisk); err != nil {
+			log.Println(err)
+			return err
+		}
 	}
 
-	// GetPositionRisk
-	positionRisk, err := s.GetPositionRisk(command)
-	if err != nil {
+	// Margin guard
+	if err := s.checkMargin(plan); err != nil {
+		log.Println(err)
 		return err
 	}
 
-	var isolatedWallet float64
-	if f, err := strconv.ParseFloat(positionRisk.IsolatedWallet, 64); err == nil {
-		isolatedWallet = f
-	}
+	// Intent log of the entry, kept until every step is done
+	workflow := s.beginWorkflow(command, closeSide, plan.TpSlMode)
 
-	// If Large position and loss ratio more than config
-	if isolatedWallet > s.config.LimitMarginSize {
-		if canOpenOrder, err := s.CheckPositionRatio(command, positionRisk); !canOpenOrder {
-			return err
-		}
+	// Setup, the entry isn't sent with the wrong leverage or margin type
+	if err := s.tradeSetup(command, plan.Leverage); err != nil {
+		log.Println(err)
+		s.lineService.Notify(fmt.Sprintf("%s [%s] ⛔ %s", command.Symbol, command.Side, err))
+		s.finishWorkflow(workflow)
+		return err
 	}
+	s.advanceWorkflow(workflow, constants.WorkflowStepSetup)
 
-	// Setup
-	s.tradeSetup(command)
+	var price string
+	if plan.LimitPrice > 0 {
+		price = utils.FormatFloat(plan.LimitPrice, pricePrecision)
+	}
 
-	// GetDecimalsInfo
-	pricePrecision, quantityPrecision := s.getDecimalsInfo(command.Symbol)
+	// The streak, funding, exposure and margin checks may have shrunk the risk sized quantity
+	if note := plan.RiskNote(); note != "" {
+		plan.Notes = append(plan.Notes, note)
+	}
 
-	quantity := s.calculateQuantity(
-		command.Symbol,
-		command.AmountUSD,
-		quantityPrecision,
-	)
+	decided = true
+	s.journalDecision(command, constants.JournalOutcomeAccepted, strings.Join(plan.Notes, ", "), map[string]interface{}{
+		"quantity":    utils.FormatFloat(plan.Quantity, quantityPrecision),
+		"notional":    plan.Notional(),
+		"leverage":    plan.Leverage,
+		"limit_price": price,
+		"add_on":      isAddOn,
+	})
 
 	// Open Order
-	s.openOrder(command.Symbol, fmt.Sprintf("%.0f", quantity), futures.SideTypeSell, futures.PositionSideTypeShort)
+	s.advanceWorkflow(workflow, constants.WorkflowStepEntryPending)
+	filled, err := s.openOrder(command.Symbol, utils.FormatFloat(plan.Quantity, quantityPrecision), price, side, command.Side, journal.ClientOrderID(command.CorrelationID, journal.StepEntry))
+	unlockExposure()
+	if err != nil {
+		s.finishWorkflow(workflow)
+		return err
+	}
+	if filled > 0 && filled < plan.Quantity {
+		// Partial IOC fill, the TP and SL close the whole position so they follow it
+		plan.Notes = append(plan.Notes, fmt.Sprintf("Partial fill: %s of %s", utils.FormatFloat(filled, quantityPrecision), utils.FormatFloat(plan.Quantity, quantityPrecision)))
+		plan.Quantity = filled
+	}
+	s.advanceWorkflow(workflow, constants.WorkflowStepEntry)
+	s.recordEntry(command.Symbol, command.Side, isAddOn, plan.EntryPrice())
+	s.recordEntryStrategy(command.Symbol, command.Side, command.Strategy)
+	s.notifyEntry(plan)
 
 	// Check is Enable SL or TP
 	if !command.IsSL && !command.IsTP {
+		s.finishWorkflow(workflow)
 		return nil
 	}
 
 	// Calcualte TP and SL
-	stopLoss, takeProfit, err := s.calculateTpSL(command.Symbol, futures.PositionSideTypeShort, pricePrecision)
+	stopLoss, takeProfit, err := s.calculateTpSL(command.Symbol, command.Side, plan.TpSlMode, pricePrecision)
 	if err != nil {
-		return err
+		// The entry went through, the retries place the TP and SL
+		s.notifyUnprotected(command, workflow, err, command.IsSL)
+		return nil
 	}
 
 	// Enable TakeProfit
+	var takeProfitErr error
 	if command.IsTP {
-		futureOrder, err := s.client.NewCreateOrderService().
-			Symbol(command.Symbol).
-			Side(futures.SideTypeBuy).
-			PositionSide(futures.PositionSideTypeShort).
-			Type(futures.OrderTypeTakeProfitMarket).
-			StopPrice(takeProfit).
-			ClosePosition(true).
-			TimeInForce(futures.TimeInForceTypeGTC).
-			WorkingType(futures.WorkingTypeMarkPrice).
-			PriceProtect(true).
-			Do(context.Background())
-		if err != nil {
-			fmt.Println("Short TP: ", err)
-			return nil
+		if err := s.placeClosePositionOrder(command.Symbol, closeSide, command.Side, futures.OrderTypeTakeProfitMarket, takeProfit, journal.ClientOrderID(command.CorrelationID, journal.StepTakeProfit)); err != nil {
+			fmt.Println(command.Side, " TP: ", err, ", TP: ", takeProfit)
+			takeProfitErr = err
+		} else {
+			fmt.Printf("Enable take profit: %s\n", takeProfit)
+			s.advanceWorkflow(workflow, constants.WorkflowStepTakeProfit)
 		}
-		fmt.Printf("Enable take profit: %s\n", takeProfit)
-		fmt.Printf("%+v\n", futureOrder)
 	}
 
 	// Enable Stop Loss
 	if command.IsSL {
-		futureOrder, err := s.client.NewCreateOrderService().
-			Symbol(command.Symbol).
-			Side(futures.SideTypeBuy).
-			PositionSide(futures.PositionSideTypeShort).
-			Type(futures.OrderTypeStopMarket).
-			StopPrice(stopLoss).
-			ClosePosition(true).
-			TimeInForce(futures.TimeInForceTypeGTC).
-			WorkingType(futures.WorkingTypeMarkPrice).
-			PriceProtect(true).
-			Do(context.Background())
-		if err != nil {
-			fmt.Println("Short SL: ", err)
+		if err := s.placeClosePositionOrder(command.Symbol, closeSide, command.Side, futures.OrderTypeStopMarket, stopLoss, journal.ClientOrderID(command.CorrelationID, journal.StepStopLoss)); err != nil {
+			fmt.Println(command.Side, " SL: ", err)
+			s.notifyUnprotected(command, workflow, err, true)
 			return nil
 		}
 		fmt.Printf("Enable stop loss: %s\n", stopLoss)
-		fmt.Printf("%+v\n", futureOrder)
+		s.advanceWorkflow(workflow, constants.WorkflowStepStopLoss)
 	}
+
+	// A failed TP keeps the workflow for the retries
+	if takeProfitErr != nil {
+		s.notifyUnprotected(command, workflow, takeProfitErr, false)
+		return nil
+	}
+	s.finishWorkflow(workflow)
 	return nil
 }
 
-func (s *service) tradeSetup(command *models.Command) {
+// tradeSetup cancels the open orders of the side and sets the leverage, the
+// margin type and the position mode, a leverage or margin type that can't be
+// set is an error
+func (s *service) tradeSetup(command *models.Command, leverage int) error {
 
 	// const { symbol, onlyOneOrder } = command
 
@@ -309,69 +497,133 @@ func (s *service) tradeSetup(command *models.Command) {
 
 	openOrders, err := s.client.NewListOpenOrdersService().Symbol(command.Symbol).Do(context.Background())
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("List Open Orders: %v", err)
 	}
 
 	if command.OnlyOneOrder && len(openOrders) > 0 {
 		fmt.Println("Skipping open order")
-		return
 	} else if len(openOrders) > 0 {
 		for _, o := range openOrders {
 			if command.Side == o.PositionSide {
 				_, err := s.client.NewCancelOrderService().Symbol(o.Symbol).OrderID(o.OrderID).Do(context.Background())
 				if err != nil {
-					fmt.Println(err)
-					return
+					return fmt.Errorf("Cancel Order %d: %v", o.OrderID, err)
 				}
 				fmt.Println("Cancal Order Symbol: ", o.Symbol, ", Order ID: ", o.OrderID)
 			}
 		}
 	}
 
+	// Reload account config if the startup load failed
+	if !s.accountConfig.isLoaded() {
+		if err := s.loadAccountConfig(); err != nil {
+			fmt.Println("Load Account Config: ", err)
+		}
+	}
+
 	// Change Leverage
-	respChangeLeverage, err := s.client.NewChangeLeverageService().
-		Symbol(command.Symbol).
-		Leverage(s.config.Leverage).
-		Do(context.Background())
-	if err != nil {
-		fmt.Println("Change Leverage: ", err)
-		return
+	if err := s.ensureLeverage(command.Symbol, leverage); err != nil {
+		return fmt.Errorf("Change Leverage: %v", err)
 	}
-	fmt.Printf("Symbol: %s, Leverage: %d, MaxNotionalValue: %s\n", respChangeLeverage.Symbol, respChangeLeverage.Leverage, respChangeLeverage.MaxNotionalValue)
 
 	// Change Margin Type
-	err = s.client.NewChangeMarginTypeService().
-		Symbol(command.Symbol).
-		MarginType(futures.MarginTypeIsolated).
-		Do(context.Background())
-	if err != nil {
-		fmt.Println("Change Margin Type: ", err)
-		// return
+	if err := s.ensureMarginType(command.Symbol, futures.MarginTypeIsolated); err != nil {
+		return fmt.Errorf("Change Margin Type: %v", err)
 	}
 
 	// Change Position Mode
-	err = s.client.NewChangePositionModeService().DualSide(true).Do(context.Background())
-	if err != nil {
+	if err := s.ensureDualSide(); err != nil {
 		fmt.Println("Change Position Mode: ", err)
 		// return
 	}
+	return nil
 }
 
-func (s *service) openOrder(symbol, quantity string, side futures.SideType, positionSide futures.PositionSideType) {
+// openOrder sends a market order, or an IOC limit order when price is set, and
+// returns the filled quantity of the IOC order, 0 for a market order. An IOC
+// order that expired without any fill is an error.
+func (s *service) openOrder(symbol, quantity, price string, side futures.SideType, positionSide futures.PositionSideType, clientOrderID string) (float64, error) {
+	request := orderRequest{
+		Symbol:        symbol,
+		Side:          side,
+		PositionSide:  positionSide,
+		Type:          futures.OrderTypeMarket,
+		Quantity:      quantity,
+		Price:         price,
+		ClientOrderID: clientOrderID,
+	}
+
 	// Start Trade
-	futureOrder, err := s.client.NewCreateOrderService().
+	orderService := s.client.NewCreateOrderService().
 		Symbol(symbol).
 		Quantity(quantity).
-		Side(side).                 //futures.SideTypeBuy
-		PositionSide(positionSide). //futures.PositionSideTypeLong
-		Type(futures.OrderTypeMarket).
-		Do(context.Background())
+		Side(side).                // futures.SideTypeBuy
+		PositionSide(positionSide) // futures.PositionSideTypeLong
+	if price != "" {
+		request.Type = futures.OrderTypeLimit
+		orderService = orderService.
+			Type(futures.OrderTypeLimit).
+			Price(price).
+			TimeInForce(futures.TimeInForceTypeIOC).
+			NewOrderResponseType(futures.NewOrderRespTypeRESULT) // final status and fill
+	} else {
+		orderService = orderService.Type(futures.OrderTypeMarket)
+	}
+	if clientOrderID != "" {
+		orderService = orderService.NewClientOrderID(clientOrderID)
+	}
+
+	futureOrder, err := orderService.Do(context.Background())
+	s.journalOrder(request, futureOrder, err)
 	if err != nil {
 		fmt.Println(err)
-		return
+		return 0, err
 	}
 	fmt.Printf("Opened position: %+v\n", futureOrder)
+
+	if price == "" {
+		return 0, nil
+	}
+	filled, _ := strconv.ParseFloat(futureOrder.ExecutedQuantity, 64)
+	if filled == 0 {
+		return 0, fmt.Errorf("entry limit order %s without fill at %s", futureOrder.Status, price)
+	}
+	return filled, nil
+}
+
+// placeClosePositionOrder sends a TP or SL order that closes the whole position at stopPrice
+func (s *service) placeClosePositionOrder(symbol string, side futures.SideType, positionSide futures.PositionSideType, orderType futures.OrderType, stopPrice, clientOrderID string) error {
+	request := orderRequest{
+		Symbol:        symbol,
+		Side:          side,
+		PositionSide:  positionSide,
+		Type:          orderType,
+		StopPrice:     stopPrice,
+		ClosePosition: true,
+		ClientOrderID: clientOrderID,
+	}
+
+	orderService := s.client.NewCreateOrderService().
+		Symbol(symbol).
+		Side(side).
+		PositionSide(positionSide).
+		Type(orderType).
+		StopPrice(stopPrice).
+		ClosePosition(true).
+		TimeInForce(futures.TimeInForceTypeGTC).
+		WorkingType(futures.WorkingTypeMarkPrice).
+		PriceProtect(true)
+	if clientOrderID != "" {
+		orderService = orderService.NewClientOrderID(clientOrderID)
+	}
+
+	futureOrder, err := orderService.Do(context.Background())
+	s.journalOrder(request, futureOrder, err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%+v\n", futureOrder)
+	return nil
 }
 
 func (s *service) getDecimalsInfo(symbol string) (int, int) {
@@ -393,14 +645,18 @@ func (s *service) getDecimalsInfo(symbol string) (int, int) {
 	return pricePrecision, quantityPrecision
 }
 
-func (s *service) calculateQuantity(symbol string, amountUSD int64, quantityPrecision int) float64 {
+// calculateQuantity returns the leveraged quantity for amountUSD and the premium index it was sized at
+func (s *service) calculateQuantity(symbol string, amountUSD int64, quantityPrecision int) (float64, *futures.PremiumIndex, error) {
 
 	fff, err := s.client.NewPremiumIndexService().
 		Symbol(symbol).
 		Do(context.Background())
 	if err != nil {
 		fmt.Println(err)
-		return 0
+		return 0, nil, err
+	}
+	if len(fff) == 0 {
+		return 0, nil, fmt.Errorf("CalculateQuantity: no mark price for %s", symbol)
 	}
 
 	markPrice := fff[0].MarkPrice
@@ -408,14 +664,17 @@ func (s *service) calculateQuantity(symbol string, amountUSD int64, quantityPrec
 	if s, err := strconv.ParseFloat(markPrice, 64); err == nil {
 		currentPrice = s
 	}
+	if currentPrice == 0 {
+		return 0, nil, fmt.Errorf("CalculateQuantity: invalid mark price %s for %s", markPrice, symbol)
+	}
 
 	quantity := utils.ToFixed(float64(amountUSD)/currentPrice, quantityPrecision)
 	leverageQuantity := quantity * float64(s.config.Leverage)
 	// fmt.Printf("Default Quantity: %f, Leverage Quantity: %f\n", quantity, leverageQuantity)
-	return leverageQuantity
+	return leverageQuantity, fff[0], nil
 }
 
-func (s *service) calculateTpSL(symbol string, side futures.PositionSideType, pricePrecision int) (string, string, error) {
+func (s *service) calculateTpSL(symbol string, side futures.PositionSideType, mode string, pricePrecision int) (string, string, error) {
 	res1, err := s.client.NewGetPositionRiskService().Symbol(symbol).Do(context.Background())
 	if err != nil {
 		log.Println("CalculateTpSL: ", err)
@@ -429,6 +688,9 @@ func (s *service) calculateTpSL(symbol string, side futures.PositionSideType, pr
 			break
 		}
 	}
+	if position == nil {
+		return "", "", fmt.Errorf("CalculateTpSL: no %s position on %s", side, symbol)
+	}
 	// fmt.Printf("Entry Price: %s\n", position.EntryPrice)
 
 	price := position.EntryPrice
@@ -437,24 +699,17 @@ func (s *service) calculateTpSL(symbol string, side futures.PositionSideType, pr
 		fmt.Println("CalculateTpSL2: ", err)
 		return "", "", err
 	}
-
-	if side == "LONG" {
-
-		stopLoss := (fPrice * (100 - s.config.StopLossPercentage)) / 100
-		takeProfit := (fPrice * (100 + s.config.TakeProfitPercentage)) / 100
-
-		// fmt.Printf("Long| Stop Loss: %s, Take Profit: %s\n", fmt.Sprintf("%f", utils.ToFixed(stopLoss, pricePrecision)), fmt.Sprintf("%f", utils.ToFixed(takeProfit, pricePrecision)))
-		return fmt.Sprintf("%f", utils.ToFixed(stopLoss, pricePrecision)), fmt.Sprintf("%f", utils.ToFixed(takeProfit, pricePrecision)), nil
-	} else if side == "SHORT" {
-
-		stopLoss := (fPrice * (100 + s.config.StopLossPercentage)) / 100
-		takeProfit := (fPrice * (100 - s.config.TakeProfitPercentage)) / 100
-
-		// fmt.Printf("Short| Stop Loss: %s, Take Profit: %s\n", fmt.Sprintf("%f", utils.ToFixed(stopLoss, pricePrecision)), fmt.Sprintf("%f", utils.ToFixed(takeProfit, pricePrecision)))
-		return fmt.Sprintf("%f", utils.ToFixed(stopLoss, pricePrecision)), fmt.Sprintf("%f", utils.ToFixed(takeProfit, pricePrecision)), nil
+	if fPrice == 0 {
+		return "", "", fmt.Errorf("CalculateTpSL: no open %s position on %s", side, symbol)
 	}
 
-	return "0", "0", nil
+	stopLoss, takeProfit, err := s.stopTakeProfitPrices(symbol, side, mode, fPrice)
+	if err != nil {
+		fmt.Println("CalculateTpSL3: ", err)
+		return "", "", err
+	}
+	// fmt.Printf("%s| Stop Loss: %f, Take Profit: %f\n", side, stopLoss, takeProfit)
+	return fmt.Sprintf("%f", utils.ToFixed(stopLoss, pricePrecision)), fmt.Sprintf("%f", utils.ToFixed(takeProfit, pricePrecision)), nil
 }
 
 func (s *service) cancelOpenOrders(command models.Command) {
@@ -464,40 +719,6 @@ func (s *service) cancelOpenOrders(command models.Command) {
 	}
 }
 
-// true = delay, false = not delay
-func (s *service) isDelayOpenOrder(command *models.Command) bool {
-	var diff time.Duration
-	var isFirst bool
-	sk := command.Symbol + string(command.Side)
-	if s.stateOrderBooks[sk] == nil { // First
-		isFirst = true
-		s.stateOrderBooks[sk] = &models.OrderBook{
-			Side:      string(command.Side),
-			TimeStamp: time.Now(),
-		}
-		return false
-	}
-
-	// compare
-	diff = time.Since(s.stateOrderBooks[sk].TimeStamp)
-	fmt.Println("Key: ", sk)
-	fmt.Println("Diff time: ", diff)
-	fmt.Println("Diff time seconds: ", diff.Seconds())
-	if isFirst || diff.Seconds() >= 330 { // 5.5min
-		// Open Order
-		fmt.Println("Open Order: Diff is ", diff.Seconds())
-
-		s.stateOrderBooks[sk] = &models.OrderBook{
-			Side:      string(command.Side),
-			TimeStamp: time.Now(),
-		}
-
-		return false
-	}
-
-	return true
-}
-
 func (s *service) GetPositionRisk(command *models.Command) (*futures.PositionRisk, error) {
 
 	orders, err := s.client.NewGetPositionRiskService().Symbol(command.Symbol).
@@ -518,176 +739,174 @@ func (s *service) GetPositionRisk(command *models.Command) (*futures.PositionRis
 	return result, nil
 }
 
-// True= Can open order, False= Skip open order
-func (s *service) CheckPositionRatio(command *models.Command, positionRisk *futures.PositionRisk) (bool, error) {
-
-	var entryPrice, markPrice float64
-	if f, err := strconv.ParseFloat(positionRisk.EntryPrice, 64); err == nil {
-		entryPrice = f
-	}
-	if f, err := strconv.ParseFloat(positionRisk.MarkPrice, 64); err == nil {
-		markPrice = f
-	}
-
-	// Don't have Open order
-	if entryPrice == 0 {
-		return true, nil
-	}
-
-	var isOverRatio bool
-	var roe float64
+// handleUserData handles one event of the user data stream
+func (s *service) handleUserData(event *futures.WsUserDataEvent) {
+	s.stream.received()
 
-	if command.Side == futures.PositionSideTypeShort { // Short
-		roe = (entryPrice - markPrice) / entryPrice * 100
-	} else if command.Side == futures.PositionSideTypeLong { // Long
-		roe = (markPrice - entryPrice) / markPrice * 100
+	if event.Event == futures.UserDataEventTypeOrderTradeUpdate {
+		o := event.OrderTradeUpdate
+		// A fill already handled, e.g. by the backfill of a reconnect
+		if o.ExecutionType == futures.OrderExecutionTypeTrade && !s.stream.markTradeSeen(o.Symbol, o.TradeID) {
+			return
+		}
+		s.handleOrderTradeUpdate(o)
+		return
 	}
 
-	if roe < s.config.WinOrLossRatio {
-		isOverRatio = true
+	// The stream ends with its listen key, reconnect with a new one
+	if event.Event == futures.UserDataEventTypeListenKeyExpired {
+		s.stream.requestRestart("listen key expired")
+		return
 	}
 
-	// isOverRatio == false
-	if !isOverRatio {
-		msg := fmt.Sprintf("Skipping Order: Side: %s, Entry Price: %f, Mark Price: %f, ROE: %.2f", command.Side, entryPrice, markPrice, utils.ToFixed(roe, 2))
-		return isOverRatio, errors.New(msg)
+	switch event.Event {
+	case futures.UserDataEventTypeAccountUpdate:
+		s.handleAccountUpdate(event)
+	case futures.UserDataEventTypeMarginCall:
+		s.handleMarginCall(event)
+	case futures.UserDataEventTypeAccountConfigUpdate:
+		s.handleAccountConfigUpdate(event)
+	default:
+		log.Printf("### 4 ###: %+v\n\n", event)
 	}
-
-	return isOverRatio, nil
 }
 
-func (s *service) listenUserData() error {
-	log.Println("streaming user data...")
+// handleOrderTradeUpdate journals and notifies an order update, fills also
+// update the streaks, the daily loss and the stop-out lockout
+func (s *service) handleOrderTradeUpdate(o futures.WsOrderTradeUpdate) {
+	s.journalFill(o)
 
-	listenKey, err := s.client.NewStartUserStreamService().Do(context.Background())
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	// Win and loss streaks
+	s.recordClosingFill(o)
 
-	s.listenKey = listenKey
-	log.Println("listenKey: ", listenKey)
+	// Closing fill, check the daily loss limit
+	if realizedPnl, _ := strconv.ParseFloat(o.RealizedPnL, 64); o.ExecutionType == futures.OrderExecutionTypeTrade && realizedPnl != 0 {
+		go func() {
+			if err := s.checkDailyLoss(); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
 
-	wsHandler := func(event *futures.WsUserDataEvent) {
-		if event.Event == futures.UserDataEventTypeOrderTradeUpdate {
-			// TP
-			if event.OrderTradeUpdate.ExecutionType == futures.OrderExecutionTypeTrade && event.OrderTradeUpdate.OriginalType == futures.OrderTypeTakeProfitMarket {
+	// TP
+	if o.ExecutionType == futures.OrderExecutionTypeTrade && o.OriginalType == futures.OrderTypeTakeProfitMarket {
 
-				msg := fmt.Sprintf(`%s [%s] 🔴 ปิด position 
+		msg := fmt.Sprintf(`%s [%s] 🔴 ปิด position 
 กำไร $%s
 ค่าคอมมิสชั่น: $%s
-			`,
-					event.OrderTradeUpdate.Symbol,
-					event.OrderTradeUpdate.PositionSide,
-					event.OrderTradeUpdate.RealizedPnL,
-					event.OrderTradeUpdate.Commission,
-				)
+	`,
+			o.Symbol,
+			o.PositionSide,
+			o.RealizedPnL,
+			o.Commission,
+		)
 
-				s.lineService.Notify(msg)
+		s.lineService.Notify(msg)
 
-				realizedPnl, err := s.calculateRealizedPnl()
-				if err != nil {
-					log.Println("RealizedPnl: ", err)
-				}
+		realizedPnl, err := s.calculateRealizedPnl()
+		if err != nil {
+			log.Println("RealizedPnl: ", err)
+			return
+		}
 
-				msg2 := fmt.Sprintf(`🔰🚸🎏🧬🧪 Net Profit
+		msg2 := fmt.Sprintf(`🔰🚸🎏🧬🧪 Net Profit
 กำไร: %.2f
 ขาดทุน: %.2f
 Commission: %.2f
 กำไรรวมวันนี้ %.2f`,
-					realizedPnl.Profit,
-					realizedPnl.Loss,
-					realizedPnl.Commission,
-					realizedPnl.NetProfit,
-				)
+			realizedPnl.Profit,
+			realizedPnl.Loss,
+			realizedPnl.Commission,
+			realizedPnl.NetProfit,
+		)
 
-				s.lineService.Notify(msg2)
+		s.lineService.Notify(msg2)
 
-				log.Printf("### TP 1 ###: %+v\n\n", event)
-			} else {
-				// Open Order
-				if event.OrderTradeUpdate.ExecutionType == futures.OrderExecutionTypeTrade {
-					var averagePrice, originalQty float64
-					if f, err := strconv.ParseFloat(event.OrderTradeUpdate.AveragePrice, 32); err == nil {
-						averagePrice = f
-					}
-					if f, err := strconv.ParseFloat(event.OrderTradeUpdate.OriginalQty, 32); err == nil {
-						originalQty = f
-					}
-
-					msg := fmt.Sprintf(`%s [%s] ✅ เปิด position
-ราคา: $%s
-จำนวน: %s
-จำนวนUSD: $%.2f
+		log.Printf("### TP 1 ###: %+v\n\n", o)
+	} else if isStopOut(o) {
+		// SL or liquidation
+		reason := "Stop loss"
+		if isLiquidation(o) {
+			reason = "Liquidation"
+		}
+		s.recordStopOut(o.Symbol, o.PositionSide)
+
+		msg := fmt.Sprintf(`%s [%s] 🛑 %s
+ขาดทุน $%s
 ค่าคอมมิสชั่น: $%s
-					`,
-						event.OrderTradeUpdate.Symbol,
-						event.OrderTradeUpdate.PositionSide,
-						event.OrderTradeUpdate.AveragePrice,
-						event.OrderTradeUpdate.OriginalQty,
-						averagePrice*originalQty,
-						event.OrderTradeUpdate.Commission,
-					)
-
-					s.lineService.Notify(msg)
-					log.Printf("### OPEN 2 ###: %+v\n\n", event)
-
-				} else {
-					s.lineService.Notify(fmt.Sprintf("`## Event: %s, Time: %d, Commission: %s", event.Event, event.Time, event.OrderTradeUpdate.Commission))
-					log.Printf("### 3 ###: %+v\n\n", event)
-				}
+Lockout: %s`,
+			o.Symbol,
+			o.PositionSide,
+			reason,
+			o.RealizedPnL,
+			o.Commission,
+			s.config.StopOutLockout,
+		)
+
+		s.lineService.Notify(msg)
+		log.Printf("### SL ###: %+v\n\n", o)
+	} else {
+		// Open Order
+		if o.ExecutionType == futures.OrderExecutionTypeTrade {
+			var averagePrice, originalQty float64
+			if f, err := strconv.ParseFloat(o.AveragePrice, 32); err == nil {
+				averagePrice = f
 			}
-		} else {
-			if event.Event == futures.UserDataEventTypeListenKeyExpired {
-				s.lineService.Notify(fmt.Sprintf("`Event:  🔴 KeyExpired 🔴, Time: %d", event.Time))
-				return
+			if f, err := strconv.ParseFloat(o.OriginalQty, 32); err == nil {
+				originalQty = f
 			}
 
-			s.lineService.Notify(fmt.Sprintf("`#4 Event: %s, Time: %d, Commission: %s", event.Event, event.Time, event.OrderTradeUpdate.Commission))
-			log.Printf("### 4 ###: %+v\n\n", event)
-		}
-	}
+			msg := fmt.Sprintf(`%s [%s] ✅ เปิด position
+ราคา: $%s
+จำนวน: %s
+จำนวนUSD: $%.2f
+ค่าคอมมิสชั่น: $%s
+			`,
+				o.Symbol,
+				o.PositionSide,
+				o.AveragePrice,
+				o.OriginalQty,
+				averagePrice*originalQty,
+				o.Commission,
+			)
 
-	errHandler := func(err error) {
-		log.Println("errHandler: ", err)
-	}
+			s.lineService.Notify(msg)
+			log.Printf("### OPEN 2 ###: %+v\n\n", o)
 
-	_, _, err = futures.WsUserDataServe(listenKey, wsHandler, errHandler)
-	if err != nil {
-		log.Println(err)
-		return err
+		} else {
+			s.lineService.Notify(fmt.Sprintf("`## Event: %s %s %s, Time: %d", futures.UserDataEventTypeOrderTradeUpdate, o.Symbol, o.ExecutionType, o.TradeTime))
+			log.Printf("### 3 ###: %+v\n\n", o)
+		}
 	}
-
-	return nil
 }
 
+// calculateRealizedPnl sums the realized PnL and commission of the current trading day
 func (s *service) calculateRealizedPnl() (*models.CalculateRealizedPnl, error) {
 
 	var profit, loss, commission float64
-	startTime := utils.EpochStartDate(time.Now()).UnixMilli()
-	endTime := utils.EpochEndDate(time.Now()).UnixMilli()
+	startTime := s.tradingDayStart().UnixMilli()
+	endTime := time.Now().UnixMilli()
 
-	trades, err := s.client.NewListAccountTradeService().StartTime(startTime).EndTime(endTime).Do(context.Background())
+	incomes, err := s.listIncome("", startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, v := range trades {
-		var realizedPnl, com float64
-		if f, err := strconv.ParseFloat(v.RealizedPnl, 64); err == nil {
-			realizedPnl = f
-		}
-
-		if f, err := strconv.ParseFloat(v.Commission, 64); err == nil {
-			com = f
+	for _, v := range incomes {
+		var income float64
+		if f, err := strconv.ParseFloat(v.Income, 64); err == nil {
+			income = f
 		}
 
-		if realizedPnl > 0 {
-			profit += realizedPnl
-			commission += com
-		} else if realizedPnl < 0 {
-			loss += realizedPnl
-			commission += com
+		switch v.IncomeType {
+		case incomeTypeRealizedPnl:
+			if income > 0 {
+				profit += income
+			} else {
+				loss += income
+			}
+		case incomeTypeCommission:
+			commission -= income
 		}
 	}
 
@@ -695,7 +914,7 @@ func (s *service) calculateRealizedPnl() (*models.CalculateRealizedPnl, error) {
 		Profit:     profit,
 		Loss:       loss,
 		Commission: commission,
-		NetProfit:  (profit - loss) - commission,
+		NetProfit:  profit + loss - commission,
 	}, nil
 
 }
@@ -705,41 +924,82 @@ func (s *service) startScheduler() {
 	// gocron.Every(1).Day().At("10:30").Do(task)
 	err := s.scheduler.Every(1).Day().At("23:59").Do(func() {
 
+		// The latency stats are kept for the next report when this one fails
 		realizedPnl, err := s.calculateRealizedPnl()
 		if err != nil {
 			log.Println("RealizedPnl: ", err)
+			s.lineService.Notify(fmt.Sprintf("⚠️ DAILY REALIZED PNL failed: %v", err))
+			return
 		}
+		latency := s.latency.report()
+
 		// 🚸🎏🧬🧪
 		msg2 := fmt.Sprintf(`🔰 DAILY REALIZED PNL
 กำไร: %.2f
 ขาดทุน: %.2f
 Commission: %.2f
-กำไรรวมวันนี้ %.2f`,
+กำไรรวมวันนี้ %.2f
+Alert Latency: avg %s, max %s (%d alerts)`,
 			realizedPnl.Profit,
 			realizedPnl.Loss,
 			realizedPnl.Commission,
 			realizedPnl.NetProfit,
+			latency.Average.Round(time.Millisecond),
+			latency.Max.Round(time.Millisecond),
+			latency.Count,
 		)
 
-		s.lineService.Notify(msg2)
+		if err := s.lineService.Notify(msg2); err != nil {
+			log.Println("Daily report: ", err)
+			return
+		}
+		s.latency.reset()
 	})
 	if err != nil {
 		log.Println("startScheduler", err)
 	}
 
-	err = s.scheduler.Every(30).Minutes().Do(func() {
-		s.lineService.Notify("🧪 extend listenKey'" + s.listenKey)
-		err := s.client.NewKeepaliveUserStreamService().ListenKey(s.listenKey).Do(context.Background())
+	// Daily loss limit reset
+	if s.isDailyLossLimitEnabled() {
+		err = s.scheduler.Every(1).Day().At(s.config.DailyResetTime).Do(s.resetDailyLoss)
 		if err != nil {
-			log.Println(err)
+			log.Println("startScheduler", err)
+		}
+	}
+
+	// Trading state expiry
+	err = s.scheduler.Every(1).Minute().Do(s.expireTradingStates)
+	if err != nil {
+		log.Println("startScheduler", err)
+	}
+
+	// Periodic reconciliation
+	if s.config.ReconcileInterval > 0 {
+		err = s.scheduler.Every(uint64(s.config.ReconcileInterval.Minutes())).Minutes().Do(func() {
+			if err := s.reconcile(); err != nil {
+				log.Println("Reconcile: ", err)
+			}
+		})
+		if err != nil {
+			log.Println("startScheduler", err)
+		}
+	}
+
+	// Stale symbol state eviction
+	err = s.scheduler.Every(1).Hour().Do(func() {
+		if evicted := s.store.EvictExpired(); evicted > 0 {
+			log.Printf("Evicted %d stale state entries\n", evicted)
 		}
 	})
+	if err != nil {
+		log.Println("startScheduler", err)
+	}
 
-	// err = s.client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(context.Background())
-	// if err != nil {
-	// 	log.Println(err)
-	// 	return err
-	// }
+	// Listen key keepalive, a key expires 60 minutes after the last one
+	err = s.scheduler.Every(30).Minutes().Do(s.keepaliveListenKey)
+	if err != nil {
+		log.Println("startScheduler", err)
+	}
 
 	// Start all the pending jobs
 	<-s.scheduler.Start()
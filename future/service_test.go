@@ -0,0 +1,83 @@
+package future
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/state/store"
+)
+
+type testLine struct{ messages []string }
+
+func (l *testLine) Notify(message string) error {
+	l.messages = append(l.messages, message)
+	return nil
+}
+
+type testJournal struct{ entries []models.JournalEntry }
+
+func (j *testJournal) Record(entry models.JournalEntry) {
+	j.entries = append(j.entries, entry)
+}
+
+func (j *testJournal) Query(filter models.JournalFilter) ([]models.JournalEntry, error) {
+	return j.entries, nil
+}
+
+// newTestService returns a service without a Binance client, enough for the
+// checks that run before any request
+func newTestService(config *models.EnvConfig) *service {
+	memoryStore := store.NewMemoryStore(time.Hour)
+	return &service{
+		config:        config,
+		store:         memoryStore,
+		lineService:   &testLine{},
+		journal:       &testJournal{},
+		tradingStates: newTradingStates(memoryStore, ""),
+		streaks:       newStreakTracker(),
+	}
+}
+
+func TestExecuteDuplicateAlert(t *testing.T) {
+	s := newTestService(&models.EnvConfig{MaxAlertAge: time.Minute})
+	command := func(alertTime time.Time) *models.Command {
+		return &models.Command{
+			Symbol:    "BTCUSDT",
+			Side:      futures.PositionSideTypeLong,
+			AlertID:   "alert-1",
+			AlertTime: alertTime,
+			IsCheckWL: true, // no whitelist, the entry fails before any request
+		}
+	}
+
+	steps := []struct {
+		name    string
+		command *models.Command
+		wantErr string
+	}{
+		{"stale first attempt", command(time.Now().Add(-time.Hour)), "Stale alert"},
+		{"resend is not a duplicate", command(time.Now()), "whlitelist"},
+		{"failed resend is not kept either", command(time.Now()), "whlitelist"},
+	}
+	for _, step := range steps {
+		_, err := s.execute(step.command, futures.SideTypeBuy, futures.SideTypeSell)
+		if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+			t.Fatalf("%s: execute error = %v, want %q", step.name, err, step.wantErr)
+		}
+	}
+
+	// An alert whose entry went through stays marked
+	if _, err := s.store.MarkAlertProcessed("alert-2"); err != nil {
+		t.Fatal(err)
+	}
+	processed := command(time.Now())
+	processed.AlertID = "alert-2"
+	_, err := s.execute(processed, futures.SideTypeBuy, futures.SideTypeSell)
+	if err == nil || !strings.Contains(err.Error(), "Duplicate alert") {
+		t.Fatalf("execute error = %v, want a duplicate alert", err)
+	}
+}
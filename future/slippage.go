@@ -0,0 +1,105 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+)
+
+// slippageDecision tells the entry path how to adjust the order after comparing
+// the alert price with the live price
+type slippageDecision struct {
+	SizeFactor float64
+	LimitPrice float64
+	LivePrice  float64
+	Deviation  float64 // adverse deviation in percent, 0 when the price moved in our favor
+}
+
+// maxSlippage returns the symbol threshold in percent, 0 means disabled
+func (s *service) maxSlippage(symbol string) float64 {
+	if f, ok := s.config.MaxSlippageSymbols[symbol]; ok {
+		return f
+	}
+	return s.config.MaxSlippagePercentage
+}
+
+// livePrice returns the mark price or the top of book price the entry would hit
+func (s *service) livePrice(symbol string, side futures.PositionSideType) (float64, error) {
+	if s.config.SlippagePriceSource == constants.PriceSourceBook {
+		tickers, err := s.client.NewListBookTickersService().Symbol(symbol).Do(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		if len(tickers) == 0 {
+			return 0, fmt.Errorf("no book ticker for %s", symbol)
+		}
+		price := tickers[0].AskPrice
+		if side == futures.PositionSideTypeShort {
+			price = tickers[0].BidPrice
+		}
+		return strconv.ParseFloat(price, 64)
+	}
+
+	premiumIndex, err := s.client.NewPremiumIndexService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	if len(premiumIndex) == 0 {
+		return 0, fmt.Errorf("no mark price for %s", symbol)
+	}
+	return strconv.ParseFloat(premiumIndex[0].MarkPrice, 64)
+}
+
+// checkSlippage compares the alert price with the live price and rejects,
+// downsizes or switches to a limit entry when the adverse deviation exceeds
+// the symbol threshold
+func (s *service) checkSlippage(command *models.Command) (*slippageDecision, error) {
+	decision := &slippageDecision{SizeFactor: 1}
+
+	threshold := s.maxSlippage(command.Symbol)
+	if command.SignalPrice <= 0 || threshold <= 0 {
+		return decision, nil
+	}
+
+	livePrice, err := s.livePrice(command.Symbol, command.Side)
+	if err != nil {
+		return nil, err
+	}
+	decision.LivePrice = livePrice
+
+	// Only a move against the position counts as slippage
+	deviation := (livePrice - command.SignalPrice) / command.SignalPrice * 100
+	if command.Side == futures.PositionSideTypeShort {
+		deviation = -deviation
+	}
+	decision.Deviation = math.Max(deviation, 0)
+
+	fmt.Printf("Slippage: %s [%s], Signal Price: %f, Live Price: %f, Deviation: %.3f%%, Max: %.3f%%\n",
+		command.Symbol, command.Side, command.SignalPrice, livePrice, decision.Deviation, threshold)
+
+	if decision.Deviation <= threshold {
+		return decision, nil
+	}
+
+	switch s.config.SlippageAction {
+	case constants.SlippageActionDownsize:
+		decision.SizeFactor = threshold / decision.Deviation
+	case constants.SlippageActionLimit:
+		tolerance := s.config.SlippageLimitTolerance
+		if command.Side == futures.PositionSideTypeShort {
+			tolerance = -tolerance
+		}
+		decision.LimitPrice = command.SignalPrice * (100 + tolerance) / 100
+	default:
+		return nil, fmt.Errorf("Slippage %.3f%% exceeds %.3f%%, Signal Price: %f, Live Price: %f",
+			decision.Deviation, threshold, command.SignalPrice, livePrice)
+	}
+
+	return decision, nil
+}
@@ -0,0 +1,158 @@
+package future
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/utils"
+)
+
+// streakTracker scales the entry size down after a run of losses until the
+// strategy or the symbol recovers, the streaks themselves are kept in the
+// state store under strategy:NAME and symbol:SYMBOL
+type streakTracker struct {
+	mu       sync.Mutex
+	orderPnl map[int64]float64 // realized PnL of partially filled closing orders
+}
+
+func newStreakTracker() *streakTracker {
+	return &streakTracker{
+		orderPnl: make(map[int64]float64),
+	}
+}
+
+func strategyStreakKey(strategy string) string {
+	return "strategy:" + strategy
+}
+
+func symbolStreakKey(symbol string) string {
+	return "symbol:" + symbol
+}
+
+// lossMultiplier returns the multiplier of the largest loss count reached in the schedule
+func (s *service) lossMultiplier(losses int) (float64, bool) {
+	var thresholds []int
+	for k := range s.config.StreakLossMultipliers {
+		if n, err := strconv.Atoi(k); err == nil && n <= losses {
+			thresholds = append(thresholds, n)
+		}
+	}
+	if len(thresholds) == 0 {
+		return 0, false
+	}
+	sort.Ints(thresholds)
+	return s.config.StreakLossMultipliers[strconv.Itoa(thresholds[len(thresholds)-1])], true
+}
+
+// recordOutcome updates the streak of key with a closed trade result
+func (s *service) recordOutcome(key string, win bool) {
+	st, ok := s.store.GetStreak(key)
+	if !ok {
+		st = models.Streak{Multiplier: 1}
+	}
+
+	if win {
+		st.Wins++
+		st.Losses = 0
+		if st.Wins >= s.config.StreakRecoveryWins {
+			st.Multiplier = 1
+		}
+	} else {
+		st.Losses++
+		st.Wins = 0
+		if m, ok := s.lossMultiplier(st.Losses); ok && m < st.Multiplier {
+			st.Multiplier = m
+		}
+	}
+
+	if err := s.store.SaveStreak(key, st); err != nil {
+		log.Println("SaveStreak: ", err)
+	}
+}
+
+// recordEntryStrategy links the open position to the strategy of the alert
+func (s *service) recordEntryStrategy(symbol string, side futures.PositionSideType, strategy string) {
+	err := s.store.UpdateOrderBook(cooldownKey(symbol, side), func(orderBook *models.OrderBook) {
+		orderBook.Strategy = strategy
+	})
+	if err != nil {
+		log.Println("RecordEntryStrategy: ", err)
+	}
+}
+
+// recordClosingFill accumulates the realized PnL of a closing order and
+// records a win or a loss once the order is filled
+func (s *service) recordClosingFill(o futures.WsOrderTradeUpdate) {
+	if len(s.config.StreakLossMultipliers) == 0 || o.ExecutionType != futures.OrderExecutionTypeTrade {
+		return
+	}
+
+	realizedPnl, err := strconv.ParseFloat(o.RealizedPnL, 64)
+	if err != nil {
+		return
+	}
+
+	s.streaks.mu.Lock()
+	defer s.streaks.mu.Unlock()
+
+	if realizedPnl != 0 {
+		s.streaks.orderPnl[o.ID] += realizedPnl
+	}
+	if o.Status != futures.OrderStatusTypeFilled {
+		return
+	}
+
+	pnl, ok := s.streaks.orderPnl[o.ID]
+	delete(s.streaks.orderPnl, o.ID)
+	if !ok || pnl == 0 {
+		return
+	}
+
+	win := pnl > 0
+	s.recordOutcome(symbolStreakKey(o.Symbol), win)
+	if orderBook, ok := s.store.GetOrderBook(cooldownKey(o.Symbol, o.PositionSide)); ok && orderBook.Strategy != "" {
+		s.recordOutcome(strategyStreakKey(orderBook.Strategy), win)
+	}
+}
+
+// applyStreak scales the planned quantity by the lower of the strategy and
+// the symbol multiplier, a quantity scaled below one lot is an error
+func (s *service) applyStreak(plan *entryPlan, strategy string) error {
+	if len(s.config.StreakLossMultipliers) == 0 {
+		return nil
+	}
+
+	s.streaks.mu.Lock()
+	defer s.streaks.mu.Unlock()
+
+	multiplier := 1.0
+	var source string
+	keys := []string{symbolStreakKey(plan.Symbol)}
+	if strategy != "" {
+		keys = append(keys, strategyStreakKey(strategy))
+	}
+	for _, key := range keys {
+		if st, ok := s.store.GetStreak(key); ok && st.Multiplier < multiplier {
+			multiplier = st.Multiplier
+			source = fmt.Sprintf("%s, %d losses / %d wins", key, st.Losses, st.Wins)
+		}
+	}
+
+	if multiplier == 1 {
+		plan.Notes = append(plan.Notes, "Streak: 1x")
+		return nil
+	}
+
+	plan.Quantity = utils.Floor(plan.Quantity*multiplier, plan.QuantityPrecision)
+	if plan.Quantity <= 0 {
+		return fmt.Errorf("Streak: %.2fx (%s), %s is below one lot", multiplier, source, plan.Symbol)
+	}
+	plan.Notes = append(plan.Notes, fmt.Sprintf("Streak: %.2fx (%s)", multiplier, source))
+	return nil
+}
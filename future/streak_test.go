@@ -0,0 +1,39 @@
+package future
+
+import (
+	"testing"
+
+	"tradingview-binance-webhook/models"
+)
+
+func TestApplyStreak(t *testing.T) {
+	tests := []struct {
+		name       string
+		quantity   float64
+		multiplier float64
+		want       float64
+		wantErr    bool
+	}{
+		{"no streak", 0.123, 1, 0.123, false},
+		{"floored to the precision", 0.123, 0.5, 0.061, false},
+		{"below one lot", 0.001, 0.5, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(&models.EnvConfig{StreakLossMultipliers: map[string]float64{"2": tt.multiplier}})
+			if err := s.store.SaveStreak(symbolStreakKey("BTCUSDT"), models.Streak{Losses: 2, Multiplier: tt.multiplier}); err != nil {
+				t.Fatal(err)
+			}
+
+			plan := &entryPlan{Symbol: "BTCUSDT", Quantity: tt.quantity, QuantityPrecision: 3}
+			err := s.applyStreak(plan, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyStreak() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && plan.Quantity != tt.want {
+				t.Errorf("applyStreak() quantity = %v, want %v", plan.Quantity, tt.want)
+			}
+		})
+	}
+}
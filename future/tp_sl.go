@@ -0,0 +1,49 @@
+package future
+
+import (
+	"fmt"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+)
+
+// tpSlMode returns the TP/SL mode of the alert, then of the symbol, then the default
+func (s *service) tpSlMode(command *models.Command) string {
+	if command.TpSlMode != "" {
+		return command.TpSlMode
+	}
+	if mode, ok := s.config.TpSlModeSymbols[command.Symbol]; ok {
+		return mode
+	}
+	if s.config.TpSlMode != "" {
+		return s.config.TpSlMode
+	}
+	return constants.TpSlModePercent
+}
+
+// stopTakeProfitPrices returns the stop loss and take profit prices for a
+// position entered at entryPrice, either a fixed percentage of entry or a
+// multiple of the ATR
+func (s *service) stopTakeProfitPrices(symbol string, side futures.PositionSideType, mode string, entryPrice float64) (float64, float64, error) {
+	stopDistance := entryPrice * s.config.StopLossPercentage / 100
+	takeProfitDistance := entryPrice * s.config.TakeProfitPercentage / 100
+
+	if mode == constants.TpSlModeATR {
+		if s.config.ATRStopLossMultiplier <= 0 || s.config.ATRTakeProfitMultiplier <= 0 {
+			return 0, 0, fmt.Errorf("ATR multipliers must be positive, SL %v TP %v", s.config.ATRStopLossMultiplier, s.config.ATRTakeProfitMultiplier)
+		}
+		atr, err := s.calculateATR(symbol, s.config.ATRInterval, s.config.ATRPeriod)
+		if err != nil {
+			return 0, 0, err
+		}
+		stopDistance = atr * s.config.ATRStopLossMultiplier
+		takeProfitDistance = atr * s.config.ATRTakeProfitMultiplier
+	}
+
+	if side == futures.PositionSideTypeShort {
+		return entryPrice + stopDistance, entryPrice - takeProfitDistance, nil
+	}
+	return entryPrice - stopDistance, entryPrice + takeProfitDistance, nil
+}
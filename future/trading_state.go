@@ -0,0 +1,200 @@
+package future
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/state"
+)
+
+// tradingStates is the runtime pause switch, global and per symbol, persisted
+// in the state store so it survives restarts
+type tradingStates struct {
+	mu      sync.Mutex
+	store   state.Store
+	Global  models.TradingState            `json:"global"`
+	Symbols map[string]models.TradingState `json:"symbols"`
+}
+
+// newTradingStates loads the states from the store, the legacy JSON file is
+// imported when the store has none yet
+func newTradingStates(store state.Store, legacyPath string) *tradingStates {
+	t := &tradingStates{
+		store:   store,
+		Global:  models.TradingState{State: constants.TradingStateRunning},
+		Symbols: make(map[string]models.TradingState),
+	}
+
+	if states, ok := store.GetTradingStates(); ok {
+		t.Global = states.Global
+		t.Symbols = states.Symbols
+		return t
+	}
+
+	data, err := ioutil.ReadFile(legacyPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Load trading state: ", err)
+		}
+		return t
+	}
+	if err := json.Unmarshal(data, t); err != nil {
+		log.Println("Load trading state: ", err)
+	}
+	if t.Symbols == nil {
+		t.Symbols = make(map[string]models.TradingState)
+	}
+	if err := t.save(); err != nil {
+		log.Println("Import trading state: ", err)
+	}
+	return t
+}
+
+// save must be called with the lock held
+func (t *tradingStates) save() error {
+	return t.store.SaveTradingStates(&models.TradingStates{Global: t.Global, Symbols: t.Symbols})
+}
+
+func isValidTradingState(state string) bool {
+	return state == constants.TradingStateRunning ||
+		state == constants.TradingStateEntriesPaused ||
+		state == constants.TradingStatePaused
+}
+
+// SetTradingState switches the state of symbol, or the global state when
+// symbol is empty, a zero until never expires
+func (s *service) SetTradingState(symbol, state string, until time.Time, actor string) error {
+	if !isValidTradingState(state) {
+		return fmt.Errorf("invalid trading state: %s", state)
+	}
+
+	t := s.tradingStates
+	t.mu.Lock()
+	ts := models.TradingState{
+		State:     state,
+		Until:     until,
+		UpdatedAt: time.Now(),
+		UpdatedBy: actor,
+	}
+	if symbol == "" {
+		t.Global = ts
+	} else if state == constants.TradingStateRunning && until.IsZero() {
+		delete(t.Symbols, symbol)
+	} else {
+		t.Symbols[symbol] = ts
+	}
+	err := t.save()
+	t.mu.Unlock()
+	if err != nil {
+		log.Println("Save trading state: ", err)
+	}
+
+	scope := "ALL"
+	if symbol != "" {
+		scope = symbol
+	}
+	s.audit.Record(actor, constants.AuditActionTradingState, map[string]interface{}{
+		"scope": scope,
+		"state": state,
+		"until": until,
+	})
+	msg := fmt.Sprintf("⏯ Trading state %s: %s (by %s)", scope, state, actor)
+	if !until.IsZero() {
+		msg += fmt.Sprintf(" until %s", until.In(s.config.Location).Format("2006-01-02 15:04 MST"))
+	}
+	log.Println(msg)
+	s.lineService.Notify(msg)
+	return nil
+}
+
+// TradingState returns the effective state of symbol, the most restrictive of
+// the global and the symbol state wins
+func (s *service) TradingState(symbol string) string {
+	t := s.tradingStates
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	state := t.Global.Effective(now)
+	if ts, ok := t.Symbols[symbol]; ok {
+		if symbolState := ts.Effective(now); tradingStateLevel(symbolState) > tradingStateLevel(state) {
+			state = symbolState
+		}
+	}
+	return state
+}
+
+// isPaused returns true when the automated orders of symbol are stopped, the
+// reconciliation fixes and the TP/SL repairs only report then
+func (s *service) isPaused(symbol string) bool {
+	return s.TradingState(symbol) == constants.TradingStatePaused
+}
+
+// GetTradingStates returns the global and the per symbol states
+func (s *service) GetTradingStates() *models.TradingStates {
+	t := s.tradingStates
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := &models.TradingStates{
+		Global:  t.Global,
+		Symbols: make(map[string]models.TradingState, len(t.Symbols)),
+	}
+	for k, v := range t.Symbols {
+		result.Symbols[k] = v
+	}
+	return result
+}
+
+// expireTradingStates resumes the states whose expiry has passed
+func (s *service) expireTradingStates() {
+	t := s.tradingStates
+	t.mu.Lock()
+
+	now := time.Now()
+	var expired []string
+	if t.Global.IsExpired(now) {
+		t.Global = models.TradingState{State: constants.TradingStateRunning, UpdatedAt: now, UpdatedBy: "expiry"}
+		expired = append(expired, "ALL")
+	}
+	for symbol, ts := range t.Symbols {
+		if ts.IsExpired(now) {
+			delete(t.Symbols, symbol)
+			expired = append(expired, symbol)
+		}
+	}
+
+	var err error
+	if len(expired) > 0 {
+		err = t.save()
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		log.Println("Save trading state: ", err)
+	}
+	for _, scope := range expired {
+		s.audit.Record("expiry", constants.AuditActionTradingState, map[string]interface{}{
+			"scope": scope,
+			"state": constants.TradingStateRunning,
+		})
+		s.lineService.Notify(fmt.Sprintf("⏯ Trading state %s: %s (expired)", scope, constants.TradingStateRunning))
+	}
+}
+
+func tradingStateLevel(state string) int {
+	switch state {
+	case constants.TradingStateEntriesPaused:
+		return 1
+	case constants.TradingStatePaused:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,379 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/models"
+)
+
+const (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = 2 * time.Minute
+	// A connection that lasted this long resets the backoff
+	streamStableAfter = time.Minute
+	// Consecutive failures before the outage is notified
+	streamNotifyAfter = 3
+	// The fills shortly before a disconnect are fetched again, the dedup
+	// drops those already handled
+	backfillOverlap = time.Minute
+	// Handled trade ids are kept this long for the dedup
+	seenTradesTTL = 24 * time.Hour
+
+	// Binance error code of a listen key that doesn't exist anymore
+	errCodeInvalidListenKey = -1125
+)
+
+// userStream is the state of the user data stream supervisor
+type userStream struct {
+	mu         sync.Mutex
+	health     models.StreamHealth
+	listenKey  string
+	restart    chan string
+	seenTrades map[string]time.Time // by symbol and trade id, ids are per symbol
+	notified   bool                 // the outage was notified, so is the recovery
+}
+
+func newUserStream() *userStream {
+	return &userStream{
+		restart:    make(chan string, 1),
+		seenTrades: make(map[string]time.Time),
+	}
+}
+
+func (u *userStream) key() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.listenKey
+}
+
+func (u *userStream) setKey(listenKey string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if listenKey != u.listenKey {
+		u.listenKey = listenKey
+		u.health.ListenKeyCreatedAt = time.Now()
+	}
+	u.health.LastKeepaliveAt = time.Now()
+}
+
+func (u *userStream) connected() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.health.Connected = true
+	u.health.ConnectedSince = time.Now()
+}
+
+// disconnected records why the stream ended, or couldn't start, and returns
+// the number of consecutive failures
+func (u *userStream) disconnected(err error, wasConnected bool) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if wasConnected {
+		u.health.Reconnects++
+		if time.Since(u.health.ConnectedSince) >= streamStableAfter {
+			u.health.ConsecutiveErrors = 0
+		}
+	}
+	u.health.Connected = false
+	u.health.ConsecutiveErrors++
+	u.health.LastError = err.Error()
+	u.health.LastErrorAt = time.Now()
+	return u.health.ConsecutiveErrors
+}
+
+func (u *userStream) setError(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.health.LastError = err.Error()
+	u.health.LastErrorAt = time.Now()
+}
+
+func (u *userStream) received() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.health.LastEventAt = time.Now()
+}
+
+// markTradeSeen returns false when the trade was already handled
+func (u *userStream) markTradeSeen(symbol string, tradeID int64) bool {
+	key := fmt.Sprintf("%s:%d", symbol, tradeID)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.seenTrades[key]; ok {
+		return false
+	}
+	u.seenTrades[key] = time.Now()
+	return true
+}
+
+func (u *userStream) pruneSeenTrades() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, seenAt := range u.seenTrades {
+		if time.Since(seenAt) > seenTradesTTL {
+			delete(u.seenTrades, key)
+		}
+	}
+}
+
+func (u *userStream) backfilled(fills int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.health.LastBackfillAt = time.Now()
+	u.health.BackfilledFills += fills
+}
+
+// requestRestart asks the supervisor to reconnect, a pending request is enough
+func (u *userStream) requestRestart(reason string) {
+	select {
+	case u.restart <- reason:
+	default:
+	}
+}
+
+func (u *userStream) drainRestart() {
+	select {
+	case <-u.restart:
+	default:
+	}
+}
+
+func (s *service) StreamHealth() models.StreamHealth {
+	s.stream.mu.Lock()
+	defer s.stream.mu.Unlock()
+	return s.stream.health
+}
+
+// listenUserData supervises the user data stream: it reconnects with an
+// exponential backoff, starts a new listen key when the old one expired and
+// backfills the fills missed while disconnected
+func (s *service) listenUserData() {
+	// Ping the connection so a dead one ends instead of hanging
+	futures.WebsocketKeepalive = true
+
+	backoff := streamMinBackoff
+	var disconnectedAt time.Time
+	for {
+		log.Println("streaming user data...")
+		connectedAt := time.Now()
+		connected, err := s.serveUserData(disconnectedAt)
+		if connected {
+			disconnectedAt = time.Now()
+			if time.Since(connectedAt) >= streamStableAfter {
+				backoff = streamMinBackoff
+			}
+		}
+
+		failures := s.stream.disconnected(err, connected)
+		log.Printf("User data stream: %v, reconnecting in %s\n", err, backoff)
+		if failures == streamNotifyAfter {
+			s.stream.mu.Lock()
+			s.stream.notified = true
+			s.stream.mu.Unlock()
+			s.lineService.Notify(fmt.Sprintf("🔌 User data stream down (%d failures): %v\nFills aren't seen until it's back", failures, err))
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// serveUserData runs one connection of the stream until it ends, connected
+// tells whether it was established. Fills after backfillFrom are fetched once
+// connected, a zero backfillFrom fetches nothing.
+func (s *service) serveUserData(backfillFrom time.Time) (connected bool, err error) {
+	// Starting returns the active key, and extends it, or a new one
+	listenKey, err := s.client.NewStartUserStreamService().Do(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("start listen key: %v", err)
+	}
+	s.stream.setKey(listenKey)
+	s.stream.drainRestart()
+
+	// Called before doneC closes, so read once it's closed
+	streamErr := errors.New("connection closed")
+	errHandler := func(err error) {
+		log.Println("errHandler: ", err)
+		s.stream.setError(err)
+		streamErr = err
+	}
+
+	doneC, stopC, err := futures.WsUserDataServe(listenKey, s.handleUserData, errHandler)
+	if err != nil {
+		return false, fmt.Errorf("connect: %v", err)
+	}
+	s.stream.connected()
+	log.Println("User data stream connected")
+
+	s.stream.mu.Lock()
+	notified := s.stream.notified
+	s.stream.notified = false
+	s.stream.mu.Unlock()
+	if notified {
+		s.lineService.Notify("🔌 User data stream is back")
+	}
+
+	if !backfillFrom.IsZero() {
+		go s.backfillFills(backfillFrom.Add(-backfillOverlap))
+	}
+
+	select {
+	case <-doneC:
+		return true, streamErr
+	case reason := <-s.stream.restart:
+		close(stopC)
+		<-doneC
+		return true, errors.New(reason)
+	}
+}
+
+// keepaliveListenKey extends the listen key, a key that expired meanwhile
+// restarts the stream with a new one
+func (s *service) keepaliveListenKey() {
+	s.stream.pruneSeenTrades()
+
+	listenKey := s.stream.key()
+	if listenKey == "" {
+		return
+	}
+
+	err := s.client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(context.Background())
+	if err != nil {
+		log.Println("KeepaliveListenKey: ", err)
+		s.stream.setError(err)
+		if isAPIErrorCode(err, errCodeInvalidListenKey) {
+			s.stream.requestRestart("listen key expired")
+		}
+		return
+	}
+	s.stream.setKey(listenKey)
+}
+
+// backfillFills handles the fills since from that the stream didn't deliver.
+// Every fill pays a commission, so the commission income tells the symbols to
+// look at, and their account trades are replayed as order updates.
+func (s *service) backfillFills(from time.Time) {
+	incomes, err := s.listIncome(incomeTypeCommission, from.UnixMilli(), time.Now().UnixMilli())
+	if err != nil {
+		log.Println("Backfill: ", err)
+		s.lineService.Notify(fmt.Sprintf("⚠️ Backfill of the missed fills failed: %v", err))
+		return
+	}
+
+	symbols := make(map[string]bool)
+	for _, income := range incomes {
+		if income.Symbol != "" {
+			symbols[income.Symbol] = true
+		}
+	}
+
+	var fills int
+	var failed []string
+	for symbol := range symbols {
+		n, err := s.backfillSymbol(symbol, from)
+		fills += n
+		if err != nil {
+			log.Printf("Backfill %s: %v\n", symbol, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", symbol, err))
+		}
+	}
+	s.stream.backfilled(fills)
+
+	log.Printf("Backfill since %s: %d fills\n", from.Format(time.RFC3339), fills)
+	if fills > 0 || len(failed) > 0 {
+		msg := fmt.Sprintf("🔁 Backfilled %d fills missed by the user data stream", fills)
+		if len(failed) > 0 {
+			msg += fmt.Sprintf("\n⚠️ Failed: %v", failed)
+		}
+		s.lineService.Notify(msg)
+	}
+}
+
+// backfillSymbol replays the unseen trades of symbol since from
+func (s *service) backfillSymbol(symbol string, from time.Time) (int, error) {
+	trades, err := s.client.NewListAccountTradeService().
+		Symbol(symbol).
+		StartTime(from.UnixMilli()).
+		Limit(1000).
+		Do(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].ID < trades[j].ID
+	})
+
+	// Only the last trade of an order carries its final status
+	lastTrade := make(map[int64]int64)
+	for _, t := range trades {
+		lastTrade[t.OrderID] = t.ID
+	}
+
+	var fills int
+	orders := make(map[int64]*futures.Order)
+	for _, t := range trades {
+		if !s.stream.markTradeSeen(t.Symbol, t.ID) {
+			continue
+		}
+
+		order, ok := orders[t.OrderID]
+		if !ok {
+			order, err = s.client.NewGetOrderService().Symbol(symbol).OrderID(t.OrderID).Do(context.Background())
+			if err != nil {
+				return fills, err
+			}
+			orders[t.OrderID] = order
+		}
+
+		s.handleOrderTradeUpdate(backfillOrderTradeUpdate(t, order, lastTrade[t.OrderID] == t.ID))
+		fills++
+	}
+	return fills, nil
+}
+
+// backfillOrderTradeUpdate builds the order update the stream would have sent for trade
+func backfillOrderTradeUpdate(trade *futures.AccountTrade, order *futures.Order, last bool) futures.WsOrderTradeUpdate {
+	status := order.Status
+	if !last {
+		status = futures.OrderStatusTypePartiallyFilled
+	}
+	return futures.WsOrderTradeUpdate{
+		Symbol:            trade.Symbol,
+		ClientOrderID:     order.ClientOrderID,
+		Side:              trade.Side,
+		Type:              order.Type,
+		OriginalType:      futures.OrderType(order.OrigType),
+		TimeInForce:       order.TimeInForce,
+		OriginalQty:       order.OrigQuantity,
+		OriginalPrice:     order.Price,
+		AveragePrice:      order.AvgPrice,
+		StopPrice:         order.StopPrice,
+		ExecutionType:     futures.OrderExecutionTypeTrade,
+		Status:            status,
+		ID:                trade.OrderID,
+		LastFilledQty:     trade.Quantity,
+		LastFilledPrice:   trade.Price,
+		CommissionAsset:   trade.CommissionAsset,
+		Commission:        trade.Commission,
+		TradeTime:         trade.Time,
+		TradeID:           trade.ID,
+		IsMaker:           trade.Maker,
+		IsReduceOnly:      order.ReduceOnly,
+		WorkingType:       order.WorkingType,
+		PositionSide:      trade.PositionSide,
+		IsClosingPosition: order.ClosePosition,
+		RealizedPnL:       trade.RealizedPnl,
+	}
+}
@@ -0,0 +1,254 @@
+package future
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/journal"
+	"tradingview-binance-webhook/models"
+)
+
+// Binance error code of an order lookup that found nothing
+const errCodeNoSuchOrder = -2013
+
+// Delays of the runtime retries of a TP/SL that couldn't be placed, the
+// last one applies the recovery policy to a position still without its SL
+var protectionRetryDelays = []time.Duration{10 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute}
+
+// beginWorkflow records the intent of an entry before any order is sent
+func (s *service) beginWorkflow(command *models.Command, closeSide futures.SideType, tpSlMode string) *models.Workflow {
+	now := time.Now()
+	workflow := &models.Workflow{
+		ID:           command.CorrelationID,
+		Symbol:       command.Symbol,
+		PositionSide: string(command.Side),
+		CloseSide:    string(closeSide),
+		TakeProfit:   command.IsTP,
+		StopLoss:     command.IsSL,
+		TpSlMode:     tpSlMode,
+		Step:         constants.WorkflowStepStarted,
+		StartedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.store.SaveWorkflow(*workflow); err != nil {
+		log.Println("Workflow: ", err)
+	}
+	return workflow
+}
+
+// advanceWorkflow records that step of the workflow is done
+func (s *service) advanceWorkflow(workflow *models.Workflow, step string) {
+	workflow.Step = step
+	workflow.UpdatedAt = time.Now()
+	if err := s.store.SaveWorkflow(*workflow); err != nil {
+		log.Println("Workflow: ", err)
+	}
+}
+
+// finishWorkflow drops the workflow once every planned step is done, or when
+// it failed before anything was opened
+func (s *service) finishWorkflow(workflow *models.Workflow) {
+	if err := s.store.DeleteWorkflow(workflow.ID); err != nil {
+		log.Println("Workflow: ", err)
+	}
+}
+
+// notifyUnprotected reports an entry whose SL or TP couldn't be placed and
+// retries them in the background, the workflow stays in the intent log until
+// they're placed so a restart meanwhile resumes it too
+func (s *service) notifyUnprotected(command *models.Command, workflow *models.Workflow, err error, stopMissing bool) {
+	state := "The SL is in place"
+	if stopMissing {
+		state = "🚨 The position is UNPROTECTED"
+	}
+	s.lineService.Notify(fmt.Sprintf("%s [%s] ⚠️ TP/SL not placed: %v\n%s, retrying in %s (entry %s)", command.Symbol, command.Side, err, state, protectionRetryDelays[0], command.CorrelationID))
+	go s.retryProtection(*workflow)
+}
+
+// retryProtection places the missing TP and SL of a running workflow
+func (s *service) retryProtection(workflow models.Workflow) {
+	var err error
+	for i, delay := range protectionRetryDelays {
+		time.Sleep(delay)
+
+		var result string
+		final := i == len(protectionRetryDelays)-1
+		result, err = s.recoverWorkflow(&workflow, final)
+		if err != nil {
+			log.Printf("RetryProtection %s: %v\n", workflow.ID, err)
+			continue
+		}
+
+		s.finishWorkflow(&workflow)
+		s.lineService.Notify(fmt.Sprintf("%s [%s] 🛡️ Entry %s: %s", workflow.Symbol, workflow.PositionSide, workflow.ID, result))
+		return
+	}
+
+	s.lineService.Notify(fmt.Sprintf("%s [%s] 🚨 Entry %s is still UNPROTECTED after %d retries: %v\nPlace the SL manually", workflow.Symbol, workflow.PositionSide, workflow.ID, len(protectionRetryDelays), err))
+}
+
+// recoverWorkflows resumes or compensates the entries interrupted by a
+// crash, it runs at startup before the server accepts alerts
+func (s *service) recoverWorkflows() {
+	workflows, err := s.store.ListWorkflows()
+	if err != nil {
+		log.Println("RecoverWorkflows: ", err)
+		return
+	}
+
+	for i := range workflows {
+		workflow := &workflows[i]
+		result, err := s.recoverWorkflow(workflow, true)
+		if err != nil {
+			result = fmt.Sprintf("recovery failed: %v", err)
+		} else {
+			s.finishWorkflow(workflow)
+		}
+
+		msg := fmt.Sprintf("%s [%s] 🔁 Unfinished entry %s (step %s): %s", workflow.Symbol, workflow.PositionSide, workflow.ID, workflow.Step, result)
+		log.Println(msg)
+		s.lineService.Notify(msg)
+	}
+}
+
+// recoverWorkflow returns what was done to the workflow. Unless final, a SL
+// that can't be placed is an error and the position is kept for a retry.
+func (s *service) recoverWorkflow(workflow *models.Workflow, final bool) (string, error) {
+	unlock := s.store.Lock(workflow.Symbol)
+	defer unlock()
+
+	switch workflow.Step {
+	case constants.WorkflowStepStarted, constants.WorkflowStepSetup:
+		return "no order was sent, dropped", nil
+
+	case constants.WorkflowStepEntryPending:
+		// The process died around the entry order, ask Binance whether it was sent
+		order, err := s.client.NewGetOrderService().
+			Symbol(workflow.Symbol).
+			OrigClientOrderID(journal.ClientOrderID(workflow.ID, journal.StepEntry)).
+			Do(context.Background())
+		if isAPIErrorCode(err, errCodeNoSuchOrder) {
+			return "entry order was never placed, dropped", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if executed, _ := strconv.ParseFloat(order.ExecutedQuantity, 64); executed == 0 {
+			return fmt.Sprintf("entry order %s without fill, dropped", order.Status), nil
+		}
+	}
+
+	if !workflow.TakeProfit && !workflow.StopLoss {
+		return "entry done, nothing to protect", nil
+	}
+
+	position, err := s.workflowPosition(workflow)
+	if err != nil {
+		return "", err
+	}
+	if position == nil {
+		return "position already closed", nil
+	}
+
+	orders, err := s.client.NewListOpenOrdersService().Symbol(workflow.Symbol).Do(context.Background())
+	if err != nil {
+		return "", err
+	}
+	// Any TP or SL of the position counts, a later alert or the
+	// reconciliation may have replaced those of the workflow
+	positionSide := futures.PositionSideType(workflow.PositionSide)
+	hasStop := hasProtectiveOrder(orders, positionSide, futures.OrderTypeStopMarket)
+	hasTakeProfit := hasProtectiveOrder(orders, positionSide, futures.OrderTypeTakeProfitMarket)
+	missing := (workflow.StopLoss && !hasStop) || (workflow.TakeProfit && !hasTakeProfit)
+
+	// No order is sent while paused, the workflow stays in the intent log
+	if missing && s.isPaused(workflow.Symbol) {
+		return "", fmt.Errorf("trading is paused, the missing TP/SL wasn't placed")
+	}
+
+	// Compensate a position left without its SL
+	if final && s.config.WorkflowRecovery == constants.WorkflowRecoveryCompensate {
+		if workflow.StopLoss && !hasStop {
+			return s.compensateWorkflow(position, "SL missing")
+		}
+		return "SL in place, position kept", nil
+	}
+
+	// Resume the missing TP and SL
+
+	var done []string
+	steps := []struct {
+		planned bool
+		stop    bool
+		step    string
+	}{
+		{workflow.TakeProfit && !hasTakeProfit, false, journal.StepTakeProfit},
+		{workflow.StopLoss && !hasStop, true, journal.StepStopLoss},
+	}
+	for _, step := range steps {
+		if !step.planned {
+			continue
+		}
+		clientOrderID := journal.ClientOrderID(workflow.ID, step.step)
+		if err := s.protectPosition(position, step.stop, workflow.TpSlMode, clientOrderID); err != nil {
+			if step.stop {
+				if !final {
+					return "", fmt.Errorf("placing the SL failed: %v", err)
+				}
+				// A position without its SL isn't left open
+				return s.compensateWorkflow(position, fmt.Sprintf("placing the SL failed (%v)", err))
+			}
+			done = append(done, fmt.Sprintf("placing the TP failed (%v)", err))
+			continue
+		}
+		done = append(done, "placed the missing "+strings.ToUpper(step.step))
+	}
+
+	if len(done) == 0 {
+		return "TP/SL already in place", nil
+	}
+	return strings.Join(done, ", "), nil
+}
+
+// hasProtectiveOrder returns true when an open TP or SL of orderType protects
+// the position side
+func hasProtectiveOrder(orders []*futures.Order, positionSide futures.PositionSideType, orderType futures.OrderType) bool {
+	for _, o := range orders {
+		if o.Type == orderType && o.PositionSide == positionSide && isProtectiveOrder(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// workflowPosition returns the open position of the workflow, nil when flat
+func (s *service) workflowPosition(workflow *models.Workflow) (*futures.PositionRisk, error) {
+	positions, err := s.client.NewGetPositionRiskService().Symbol(workflow.Symbol).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range positions {
+		if p.PositionSide != workflow.PositionSide {
+			continue
+		}
+		if amount, err := strconv.ParseFloat(p.PositionAmt, 64); err == nil && amount != 0 {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// compensateWorkflow closes the naked position of the workflow
+func (s *service) compensateWorkflow(position *futures.PositionRisk, reason string) (string, error) {
+	if err := s.closePosition(position); err != nil {
+		return "", fmt.Errorf("closing the naked position failed: %v", err)
+	}
+	return reason + ", closed the naked position", nil
+}
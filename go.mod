@@ -11,6 +11,7 @@ require (
 	github.com/go-chi/render v1.0.1
 	github.com/jasonlvhit/gocron v0.0.1
 	github.com/sirupsen/logrus v1.8.1
+	go.etcd.io/bbolt v1.3.6
 )
 
 require (
@@ -0,0 +1,44 @@
+package journal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Our client order ids are "<correlation id>-<step>", fills are linked back
+// to the alert through them. Binance allows up to 36 characters.
+const correlationPrefix = "tv"
+
+// Order steps used in the client order ids
+const (
+	StepEntry      = "e"
+	StepTakeProfit = "tp"
+	StepStopLoss   = "sl"
+	StepClose      = "x"
+)
+
+// NewCorrelationID returns a random id that links the entries of one alert
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return correlationPrefix + hex.EncodeToString(b)
+}
+
+// ClientOrderID returns the client order id of step of the correlation
+func ClientOrderID(correlationID, step string) string {
+	if correlationID == "" {
+		return ""
+	}
+	return correlationID + "-" + step
+}
+
+// ParseClientOrderID returns the correlation id and the step of one of our
+// client order ids, false for orders placed outside the bot
+func ParseClientOrderID(clientOrderID string) (string, string, bool) {
+	correlationID, step, ok := strings.Cut(clientOrderID, "-")
+	if !ok || !strings.HasPrefix(correlationID, correlationPrefix) || len(correlationID) != len(correlationPrefix)+16 {
+		return "", "", false
+	}
+	return correlationID, step, true
+}
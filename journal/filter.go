@@ -0,0 +1,52 @@
+package journal
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/utils"
+)
+
+// ParseFilter reads a filter from the query API parameters or the CLI flags:
+// id, symbol, strategy, outcome, type, from, to and limit. from and to are
+// RFC3339, "2006-01-02 15:04" or a date in loc, a date to includes the whole day
+func ParseFilter(values url.Values, loc *time.Location) (models.JournalFilter, error) {
+	filter := models.JournalFilter{
+		CorrelationID: values.Get("id"),
+		Symbol:        strings.ToUpper(values.Get("symbol")),
+		Strategy:      values.Get("strategy"),
+		Outcome:       values.Get("outcome"),
+		Type:          values.Get("type"),
+	}
+
+	if v := values.Get("from"); v != "" {
+		t, _, err := utils.ParseTime(v, loc)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %s", v)
+		}
+		filter.From = t
+	}
+	if v := values.Get("to"); v != "" {
+		t, isDate, err := utils.ParseTime(v, loc)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %s", v)
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		filter.To = t
+	}
+	if v := values.Get("limit"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		filter.Limit = i
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,8 @@
+package journal
+
+import "tradingview-binance-webhook/models"
+
+type Service interface {
+	Record(entry models.JournalEntry)
+	Query(filter models.JournalFilter) ([]models.JournalEntry, error)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tradingview-binance-webhook/journal"
+	"tradingview-binance-webhook/models"
+)
+
+// One JSON line per entry, one file per UTC day
+const (
+	dayLayout   = "2006-01-02"
+	fileSuffix  = ".jsonl"
+	maxLineSize = 1024 * 1024
+)
+
+type journalService struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewJournalService appends the entries to daily JSON lines files in dir, the
+// files are only appended to so they can be read while the bot is running
+func NewJournalService(dir string) journal.Service {
+	return &journalService{
+		dir: dir,
+	}
+}
+
+func (j *journalService) Record(entry models.JournalEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Journal: ", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		log.Println("Journal: ", err)
+		return
+	}
+
+	path := filepath.Join(j.dir, entry.Time.UTC().Format(dayLayout)+fileSuffix)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Println("Journal: ", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Println("Journal: ", err)
+	}
+}
+
+// Query returns every entry of the correlations with at least one entry
+// matching the filter, oldest first
+func (j *journalService) Query(filter models.JournalFilter) ([]models.JournalEntry, error) {
+	files, err := j.files(filter.From, filter.To)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.JournalEntry
+	for _, path := range files {
+		fileEntries, err := readEntries(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range fileEntries {
+			if (filter.From.IsZero() || !e.Time.Before(filter.From)) && (filter.To.IsZero() || e.Time.Before(filter.To)) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	// Correlations with a matching entry, entries without a correlation stand alone
+	correlations := make(map[string]bool)
+	var result []models.JournalEntry
+	for _, e := range entries {
+		if matches(e, filter) && e.CorrelationID != "" {
+			correlations[e.CorrelationID] = true
+		}
+	}
+	for _, e := range entries {
+		if correlations[e.CorrelationID] || (e.CorrelationID == "" && matches(e, filter)) {
+			result = append(result, e)
+		}
+	}
+
+	sort.SliceStable(result, func(a, b int) bool {
+		return result[a].Time.Before(result[b].Time)
+	})
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result, nil
+}
+
+// files returns the daily files that may hold entries between from and to
+func (j *journalService) files(from, to time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(name, fileSuffix))
+		if err != nil {
+			continue
+		}
+		if !from.IsZero() && !day.AddDate(0, 0, 1).After(from) {
+			continue
+		}
+		if !to.IsZero() && !day.Before(to) {
+			continue
+		}
+		files = append(files, filepath.Join(j.dir, name))
+	}
+	return files, nil
+}
+
+func readEntries(path string) ([]models.JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []models.JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var e models.JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A torn last line after a crash, skip it
+			log.Printf("Journal %s: %v\n", filepath.Base(path), err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func matches(e models.JournalEntry, filter models.JournalFilter) bool {
+	return (filter.CorrelationID == "" || e.CorrelationID == filter.CorrelationID) &&
+		(filter.Symbol == "" || e.Symbol == filter.Symbol) &&
+		(filter.Strategy == "" || e.Strategy == filter.Strategy) &&
+		(filter.Outcome == "" || strings.EqualFold(e.Outcome, filter.Outcome)) &&
+		(filter.Type == "" || e.Type == filter.Type)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,11 +20,19 @@ import (
 	"github.com/jasonlvhit/gocron"
 	"github.com/joho/godotenv"
 
+	_auditService "tradingview-binance-webhook/audit/service"
+	_calendarService "tradingview-binance-webhook/calendar/service"
 	"tradingview-binance-webhook/client"
+	"tradingview-binance-webhook/constants"
+	_exportService "tradingview-binance-webhook/export/service"
 	"tradingview-binance-webhook/future"
+	_journalService "tradingview-binance-webhook/journal/service"
 	_lineService "tradingview-binance-webhook/line/service"
 	"tradingview-binance-webhook/models"
 	"tradingview-binance-webhook/server"
+	"tradingview-binance-webhook/state"
+	_stateStore "tradingview-binance-webhook/state/store"
+	"tradingview-binance-webhook/utils"
 )
 
 var config models.EnvConfig
@@ -53,20 +65,286 @@ func init() {
 		config.StopLossPercentage = f
 	}
 
-	if f, err := strconv.ParseFloat(os.Getenv("LIMIT_MARGIN_SIZE"), 32); err == nil {
-		config.LimitMarginSize = f
+	tokenWhitelist := strings.Split(os.Getenv("TOKEN_WHITELIST"), ",")
+	config.TokenWhitelist = tokenWhitelist
+
+	// Slippage guard
+	if f, err := strconv.ParseFloat(os.Getenv("MAX_SLIPPAGE_PERCENTAGE"), 64); err == nil {
+		config.MaxSlippagePercentage = f
+	}
+	config.MaxSlippageSymbols = utils.ParseFloatMap(os.Getenv("MAX_SLIPPAGE_SYMBOLS"))
+	config.SlippageAction = os.Getenv("SLIPPAGE_ACTION")
+	config.SlippagePriceSource = os.Getenv("SLIPPAGE_PRICE_SOURCE")
+	if f, err := strconv.ParseFloat(os.Getenv("SLIPPAGE_LIMIT_TOLERANCE_PERCENTAGE"), 64); err == nil {
+		config.SlippageLimitTolerance = f
 	}
 
-	if f, err := strconv.ParseFloat(os.Getenv("WIN_OR_LOSS_RATIO"), 32); err == nil {
-		config.WinOrLossRatio = f
+	// Stale alert rejection
+	if i, err := strconv.Atoi(os.Getenv("MAX_ALERT_AGE_SECONDS")); err == nil {
+		config.MaxAlertAge = time.Duration(i) * time.Second
 	}
 
-	tokenWhitelist := strings.Split(os.Getenv("TOKEN_WHITELIST"), ",")
-	config.TokenWhitelist = tokenWhitelist
+	// Time zone
+	timeZone := os.Getenv("TIME_ZONE")
+	if timeZone == "" {
+		timeZone = "Asia/Bangkok"
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		log.Println("Unfortunately can't load a location", err)
+		location = time.Local
+	}
+	config.Location = location
+
+	config.DailyResetTime = os.Getenv("DAILY_RESET_TIME")
+	if config.DailyResetTime == "" {
+		config.DailyResetTime = "00:00"
+	}
+
+	// Daily loss limit
+	if f, err := strconv.ParseFloat(os.Getenv("DAILY_LOSS_LIMIT"), 64); err == nil {
+		config.DailyLossLimit = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("DAILY_LOSS_LIMIT_PERCENTAGE"), 64); err == nil {
+		config.DailyLossLimitPercentage = f
+	}
+	config.DailyLossIncludeUnrealized = os.Getenv("DAILY_LOSS_INCLUDE_UNREALIZED") == "true"
+	config.DailyLossFlatten = os.Getenv("DAILY_LOSS_FLATTEN") == "true"
+
+	// Exposure caps
+	if i, err := strconv.Atoi(os.Getenv("MAX_OPEN_POSITIONS")); err == nil {
+		config.MaxOpenPositions = i
+	}
+	if i, err := strconv.Atoi(os.Getenv("MAX_LONG_POSITIONS")); err == nil {
+		config.MaxLongPositions = i
+	}
+	if i, err := strconv.Atoi(os.Getenv("MAX_SHORT_POSITIONS")); err == nil {
+		config.MaxShortPositions = i
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("MAX_SYMBOL_NOTIONAL"), 64); err == nil {
+		config.MaxSymbolNotional = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("MAX_TOTAL_NOTIONAL"), 64); err == nil {
+		config.MaxTotalNotional = f
+	}
+	if path := os.Getenv("SYMBOL_GROUPS_FILE"); path != "" {
+		symbolGroups, err := loadSymbolGroups(path)
+		if err != nil {
+			log.Fatalf("Symbol groups: %v", err)
+		}
+		config.SymbolGroups = symbolGroups
+	}
+
+	// Margin guard
+	config.MarginGuard = os.Getenv("MARGIN_GUARD") == "true"
+	config.MarginGuardAction = os.Getenv("MARGIN_GUARD_ACTION")
+	if f, err := strconv.ParseFloat(os.Getenv("MAX_MARGIN_RATIO"), 64); err == nil {
+		config.MaxMarginRatio = f
+	}
+	config.MarginAsset = os.Getenv("MARGIN_ASSET")
+	if config.MarginAsset == "" {
+		config.MarginAsset = "USDT"
+	}
+
+	// Liquidation guard
+	config.LiquidationGuard = os.Getenv("LIQUIDATION_GUARD")
+	if f, err := strconv.ParseFloat(os.Getenv("LIQUIDATION_BUFFER_PERCENTAGE"), 64); err == nil {
+		config.LiquidationBufferPercentage = f
+	}
+	if i, err := strconv.Atoi(os.Getenv("MAX_LEVERAGE")); err == nil {
+		config.MaxLeverage = i
+	}
+
+	// TP/SL mode
+	config.TpSlMode = os.Getenv("TP_SL_MODE")
+	config.TpSlModeSymbols = utils.ParseStringMap(os.Getenv("TP_SL_MODE_SYMBOLS"))
+	config.ATRInterval = os.Getenv("ATR_INTERVAL")
+	if config.ATRInterval == "" {
+		config.ATRInterval = "15m"
+	}
+	config.ATRPeriod = 14
+	if i, err := strconv.Atoi(os.Getenv("ATR_PERIOD")); err == nil && i > 0 {
+		config.ATRPeriod = i
+	}
+	config.ATRStopLossMultiplier = 1.5
+	if v := os.Getenv("ATR_SL_MULTIPLIER"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			log.Fatalf("Invalid ATR_SL_MULTIPLIER: %s", v)
+		}
+		config.ATRStopLossMultiplier = f
+	}
+	config.ATRTakeProfitMultiplier = 3
+	if v := os.Getenv("ATR_TP_MULTIPLIER"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			log.Fatalf("Invalid ATR_TP_MULTIPLIER: %s", v)
+		}
+		config.ATRTakeProfitMultiplier = f
+	}
+
+	// Cooldown policies
+	config.Cooldown = 330 * time.Second // 5.5min
+	if i, err := strconv.Atoi(os.Getenv("COOLDOWN_SECONDS")); err == nil {
+		config.Cooldown = time.Duration(i) * time.Second
+	}
+	config.CooldownSymbols = utils.ParseDurationMap(os.Getenv("COOLDOWN_SYMBOLS"))
+	config.CooldownSides = utils.ParseDurationMap(os.Getenv("COOLDOWN_SIDES"))
+	if i, err := strconv.Atoi(os.Getenv("MAX_ENTRIES_PER_WINDOW")); err == nil {
+		config.MaxEntriesPerWindow = i
+	}
+	config.EntryWindow = time.Hour
+	if i, err := strconv.Atoi(os.Getenv("ENTRY_WINDOW_SECONDS")); err == nil {
+		config.EntryWindow = time.Duration(i) * time.Second
+	}
+	if i, err := strconv.Atoi(os.Getenv("STOP_OUT_LOCKOUT_SECONDS")); err == nil {
+		config.StopOutLockout = time.Duration(i) * time.Second
+	}
+
+	// Trading sessions and blackout calendar
+	config.BlackoutWindows = os.Getenv("BLACKOUT_WINDOWS")
+	config.BlackoutWeekends = os.Getenv("BLACKOUT_WEEKENDS") == "true"
+	if i, err := strconv.Atoi(os.Getenv("FUNDING_BLACKOUT_MINUTES")); err == nil {
+		config.FundingBlackout = time.Duration(i) * time.Minute
+	}
+	config.BlackoutCalendarFile = os.Getenv("BLACKOUT_CALENDAR_FILE")
+
+	// Funding rate filter
+	if f, err := strconv.ParseFloat(os.Getenv("FUNDING_RATE_THRESHOLD"), 64); err == nil {
+		config.FundingRateThreshold = f
+	}
+	if i, err := strconv.Atoi(os.Getenv("FUNDING_WINDOW_MINUTES")); err == nil {
+		config.FundingWindow = time.Duration(i) * time.Minute
+	}
+	config.FundingAction = os.Getenv("FUNDING_ACTION")
+	config.FundingShrinkFactor = 0.5
+	if f, err := strconv.ParseFloat(os.Getenv("FUNDING_SHRINK_FACTOR"), 64); err == nil {
+		config.FundingShrinkFactor = f
+	}
+
+	// Add-on policy
+	config.AddOnMode = constants.AddOnModeAverage
+	if mode := os.Getenv("ADD_ON_MODE"); mode != "" {
+		config.AddOnMode = mode
+	}
+	if i, err := strconv.Atoi(os.Getenv("ADD_ON_MAX_ADDS")); err == nil {
+		config.AddOnMaxAdds = i
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("ADD_ON_MIN_DISTANCE_PERCENTAGE"), 64); err == nil {
+		config.AddOnMinDistancePercentage = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("ADD_ON_MIN_ROE_PERCENTAGE"), 64); err == nil {
+		config.AddOnMinRoePercentage = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("ADD_ON_MAX_MARGIN"), 64); err == nil {
+		config.AddOnMaxMargin = f
+	}
+	// Legacy add-on limits, kept working until the .env is migrated
+	if v := os.Getenv("LIMIT_MARGIN_SIZE"); v != "" {
+		log.Println("LIMIT_MARGIN_SIZE is deprecated, use ADD_ON_MAX_MARGIN")
+		if f, err := strconv.ParseFloat(v, 64); err == nil && os.Getenv("ADD_ON_MAX_MARGIN") == "" {
+			config.AddOnMaxMargin = f
+		}
+	}
+	if v := os.Getenv("WIN_OR_LOSS_RATIO"); v != "" {
+		log.Println("WIN_OR_LOSS_RATIO is deprecated, use ADD_ON_MIN_ROE_PERCENTAGE")
+		if f, err := strconv.ParseFloat(v, 64); err == nil && os.Getenv("ADD_ON_MIN_ROE_PERCENTAGE") == "" {
+			config.AddOnMinRoePercentage = math.Abs(f)
+		}
+	}
+	config.AddOnSizeMultiplier = 1
+	if f, err := strconv.ParseFloat(os.Getenv("ADD_ON_SIZE_MULTIPLIER"), 64); err == nil && f > 0 {
+		config.AddOnSizeMultiplier = f
+	}
+
+	// Reconciliation
+	config.ReconcilePolicy = os.Getenv("RECONCILE_POLICY")
+	if config.ReconcilePolicy == "" {
+		config.ReconcilePolicy = constants.ReconcilePolicyReport
+	}
+	if i, err := strconv.Atoi(os.Getenv("RECONCILE_INTERVAL_MINUTES")); err == nil && i > 0 {
+		config.ReconcileInterval = time.Duration(i) * time.Minute
+	}
+	config.ReconcileRequireSL = os.Getenv("RECONCILE_REQUIRE_SL") != "false"
+	config.ReconcileRequireTP = os.Getenv("RECONCILE_REQUIRE_TP") == "true"
+
+	// Workflow recovery
+	config.WorkflowRecovery = os.Getenv("WORKFLOW_RECOVERY")
+	if config.WorkflowRecovery == "" {
+		config.WorkflowRecovery = constants.WorkflowRecoveryResume
+	}
+
+	// Loss streak size scaling
+	config.StreakLossMultipliers = utils.ParseFloatMap(os.Getenv("STREAK_LOSS_MULTIPLIERS"))
+	config.StreakRecoveryWins = 2
+	if i, err := strconv.Atoi(os.Getenv("STREAK_RECOVERY_WINS")); err == nil && i > 0 {
+		config.StreakRecoveryWins = i
+	}
+
+	// Trade and income export
+	config.ExportQuoteCurrency = strings.ToUpper(os.Getenv("EXPORT_QUOTE_CURRENCY"))
+	if config.ExportQuoteCurrency == "" {
+		config.ExportQuoteCurrency = "USDT"
+	}
+
+	// Admin API and runtime state
+	config.AdminToken = os.Getenv("ADMIN_TOKEN")
+	config.StateDir = os.Getenv("STATE_DIR")
+	if config.StateDir == "" {
+		config.StateDir = "data"
+	}
+	config.TradingStateFile = filepath.Join(config.StateDir, "trading_state.json")
+	config.JournalDir = filepath.Join(config.StateDir, "journal")
+	config.AuditDir = filepath.Join(config.StateDir, "audit")
+	config.AuditMaxFileSize = 10 * 1024 * 1024
+	if i, err := strconv.ParseInt(os.Getenv("AUDIT_MAX_FILE_SIZE_MB"), 10, 64); err == nil && i > 0 {
+		config.AuditMaxFileSize = i * 1024 * 1024
+	}
+	config.StateBackend = os.Getenv("STATE_BACKEND")
+	if config.StateBackend == "" {
+		config.StateBackend = constants.StateBackendBolt
+	}
+	config.StateTTL = 7 * 24 * time.Hour
+	if d, err := time.ParseDuration(os.Getenv("STATE_TTL")); err == nil {
+		config.StateTTL = d
+	}
+}
+
+// auditConfig returns the loaded config without its secrets. The config is
+// only read at startup, there's no reload, so a change shows as the config
+// entry of the next startup
+func auditConfig() models.EnvConfig {
+	c := config
+	c.BinanceAPIKey = ""
+	c.BinanceAPISecret = ""
+	c.LineNotifyToken = ""
+	c.AdminToken = ""
+	return c
+}
+
+func loadSymbolGroups(path string) ([]models.SymbolGroup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var symbolGroups []models.SymbolGroup
+	if err := json.Unmarshal(data, &symbolGroups); err != nil {
+		return nil, err
+	}
+	return symbolGroups, nil
 }
 
 func main() {
 
+	// Commands
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	// Http Client
@@ -77,25 +355,58 @@ func main() {
 
 	// NewScheduler
 	scheduler := gocron.NewScheduler()
-	location, err := time.LoadLocation("Asia/Bangkok")
+	scheduler.ChangeLoc(config.Location)
+
+	// Line
+	lineService := _lineService.NewLineService(config.LineNotifyToken, cc)
+
+	// Calendar
+	blackoutWindows, err := _calendarService.ParseWindows(config.BlackoutWindows)
 	if err != nil {
-		log.Println("Unfortunately can't load a location")
+		log.Fatalf("Blackout windows: %v", err)
+	}
+	if config.BlackoutWeekends {
+		blackoutWindows = append(blackoutWindows, _calendarService.WeekendWindows()...)
+	}
+	var blackoutEvents []models.BlackoutEvent
+	if config.BlackoutCalendarFile != "" {
+		blackoutEvents, err = _calendarService.LoadEvents(config.BlackoutCalendarFile, config.Location)
+		if err != nil {
+			log.Fatalf("Blackout calendar: %v", err)
+		}
+		log.Printf("Loaded %d blackout events\n", len(blackoutEvents))
+	}
+	calendarService := _calendarService.NewCalendarService(config.Location, blackoutWindows, config.FundingBlackout, blackoutEvents)
+
+	// State is loaded before the server accepts alerts
+	var stateStore state.Store
+	if config.StateBackend == constants.StateBackendMemory {
+		stateStore = _stateStore.NewMemoryStore(config.StateTTL)
 	} else {
-		scheduler.ChangeLoc(location)
+		stateStore, err = _stateStore.NewBoltStore(filepath.Join(config.StateDir, "state.db"), config.StateTTL)
+		if err != nil {
+			log.Fatalf("State store: %v", err)
+		}
 	}
+	defer stateStore.Close()
 
-	// Line
-	lineService := _lineService.NewLineService(config.LineNotifyToken, cc)
+	journalService := _journalService.NewJournalService(config.JournalDir)
 
-	stateOrderBooks := make(map[string]*models.OrderBook)
+	auditService, err := _auditService.NewAuditService(config.AuditDir, config.AuditMaxFileSize)
+	if err != nil {
+		log.Fatalf("Audit log: %v", err)
+	}
+	auditService.Record("startup", constants.AuditActionConfig, auditConfig())
 
 	futuresClient := binance.NewFuturesClient(config.BinanceAPIKey, config.BinanceAPISecret) // USDT-M Futures
 
 	// Services
-	futureSvc := future.NewService(&config, stateOrderBooks, futuresClient, lineService, scheduler)
+	futureSvc := future.NewService(&config, stateStore, futuresClient, lineService, calendarService, journalService, auditService, scheduler)
+
+	exportService := _exportService.NewExportService(futuresClient)
 
 	// Server
-	srv := server.New(futuresClient, futureSvc)
+	srv := server.New(futuresClient, futureSvc, journalService, auditService, exportService, config.Location, config.ExportQuoteCurrency, config.AdminToken)
 
 	errs := make(chan error, 2)
 	go func() {
@@ -107,6 +418,20 @@ func main() {
 		signal.Notify(c, syscall.SIGINT)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
+	go func() {
+		// SIGUSR1 pauses new entries, SIGUSR2 resumes
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+		for sig := range c {
+			state := constants.TradingStateEntriesPaused
+			if sig == syscall.SIGUSR2 {
+				state = constants.TradingStateRunning
+			}
+			if err := futureSvc.SetTradingState("", state, time.Time{}, "signal "+sig.String()); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
 
 	log.Println("terminated", <-errs)
 
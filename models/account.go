@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// AccountBalance is the wallet balance of one asset
+type AccountBalance struct {
+	Asset              string    `json:"asset"`
+	WalletBalance      float64   `json:"wallet_balance"`
+	CrossWalletBalance float64   `json:"cross_wallet_balance"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// AccountPosition is one open position as last reported by Binance
+type AccountPosition struct {
+	Symbol         string    `json:"symbol"`
+	Side           string    `json:"side"`
+	Amount         float64   `json:"amount"`
+	EntryPrice     float64   `json:"entry_price"`
+	UnrealizedPnl  float64   `json:"unrealized_pnl"`
+	MarginType     string    `json:"margin_type"`
+	IsolatedWallet float64   `json:"isolated_wallet"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// AccountSnapshot is the account kept up to date by the user data stream
+type AccountSnapshot struct {
+	Balances      []AccountBalance   `json:"balances"`
+	Positions     []AccountPosition  `json:"positions"`
+	FundingFees   map[string]float64 `json:"funding_fees"` // per asset since the start, negative is paid
+	LastFundingAt time.Time          `json:"last_funding_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
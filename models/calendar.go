@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// BlackoutWindow is a recurring window on the given weekdays, Start and End
+// are offsets from midnight in the calendar time zone
+type BlackoutWindow struct {
+	Weekdays []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Reason   string
+}
+
+// BlackoutEvent is a one-off blackout range such as a scheduled macro event
+type BlackoutEvent struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
@@ -1,15 +1,33 @@
 package models
 
 import (
+	"time"
+
 	"github.com/adshao/go-binance/v2/futures"
 )
 
 type Command struct {
-	Symbol       string
-	Side         futures.PositionSideType
-	AmountUSD    int64
-	IsTP         bool
-	IsSL         bool
-	IsCheckWL    bool
-	OnlyOneOrder bool
+	Symbol         string
+	Side           futures.PositionSideType
+	AmountUSD      int64
+	IsTP           bool
+	IsSL           bool
+	IsCheckWL      bool
+	OnlyOneOrder   bool
+	SignalPrice    float64   // price from the alert, e.g. {{close}}
+	AlertTime      time.Time // firing time of the alert, {{timenow}}
+	TpSlMode       string    // percent or atr, empty uses the config
+	RiskPercentage float64   // risk per trade in percent of equity, 0 sizes by AmountUSD
+	Strategy       string    // strategy name, used by the loss streak scaling
+	AlertID        string    // unique alert id, a repeated id is rejected
+	CorrelationID  string    // links the journal entries of the alert
+	ReceivedAt     time.Time
+}
+
+// CommandResult is returned to the webhook caller after the command was executed
+type CommandResult struct {
+	Symbol           string                   `json:"symbol"`
+	Side             futures.PositionSideType `json:"side"`
+	AlertLatencyMs   int64                    `json:"alert_latency_ms,omitempty"`
+	ProcessingTimeMs int64                    `json:"processing_time_ms"`
 }
@@ -8,14 +8,140 @@ type EnvConfig struct {
 	Leverage             int
 	TakeProfitPercentage float64
 	StopLossPercentage   float64
-	LimitMarginSize      float64
-	WinOrLossRatio       float64
 	Port                 string
 	TokenWhitelist       []string
 	LineNotifyToken      string
+
+	// Slippage guard
+	MaxSlippagePercentage  float64
+	MaxSlippageSymbols     map[string]float64
+	SlippageAction         string
+	SlippagePriceSource    string
+	SlippageLimitTolerance float64
+
+	// Stale alert rejection, 0 means disabled
+	MaxAlertAge time.Duration
+
+	// Time zone of the scheduler and the trading day
+	Location       *time.Location
+	DailyResetTime string
+
+	// Daily loss limit, 0 means disabled
+	DailyLossLimit             float64
+	DailyLossLimitPercentage   float64
+	DailyLossIncludeUnrealized bool
+	DailyLossFlatten           bool
+
+	// Exposure caps, 0 means unlimited
+	MaxOpenPositions  int
+	MaxLongPositions  int
+	MaxShortPositions int
+	MaxSymbolNotional float64
+	MaxTotalNotional  float64
+	SymbolGroups      []SymbolGroup
+
+	// Margin guard
+	MarginGuard       bool
+	MarginGuardAction string
+	MaxMarginRatio    float64
+	MarginAsset       string
+
+	// Liquidation guard, empty means disabled
+	LiquidationGuard            string
+	LiquidationBufferPercentage float64
+	MaxLeverage                 int
+
+	// TP/SL mode, percent of entry or multiple of the ATR
+	TpSlMode                string
+	TpSlModeSymbols         map[string]string
+	ATRInterval             string
+	ATRPeriod               int
+	ATRStopLossMultiplier   float64
+	ATRTakeProfitMultiplier float64
+
+	// Cooldown policies
+	Cooldown            time.Duration
+	CooldownSymbols     map[string]time.Duration // SYMBOL or SYMBOL/SIDE
+	CooldownSides       map[string]time.Duration
+	MaxEntriesPerWindow int
+	EntryWindow         time.Duration
+	StopOutLockout      time.Duration
+
+	// Trading sessions and blackout calendar
+	BlackoutWindows      string
+	BlackoutWeekends     bool
+	FundingBlackout      time.Duration
+	BlackoutCalendarFile string
+
+	// Funding rate filter, threshold in percent, 0 means disabled
+	FundingRateThreshold float64
+	FundingWindow        time.Duration
+	FundingAction        string
+	FundingShrinkFactor  float64
+
+	// Add-on policy for entries on an open position
+	AddOnMode                  string
+	AddOnMaxAdds               int     // 0 means no limit
+	AddOnMinDistancePercentage float64 // price move from the last entry or add-on
+	AddOnMinRoePercentage      float64
+	AddOnMaxMargin             float64 // total isolated margin of the position, 0 means no cap
+	AddOnSizeMultiplier        float64
+
+	// Reconciliation of the positions and their protective orders
+	ReconcilePolicy    string
+	ReconcileInterval  time.Duration // 0 reconciles only at startup
+	ReconcileRequireSL bool
+	ReconcileRequireTP bool
+
+	// Recovery of the entries interrupted by a crash
+	WorkflowRecovery string
+
+	// Loss streak size scaling, losses -> multiplier, empty means disabled
+	StreakLossMultipliers map[string]float64
+	StreakRecoveryWins    int
+
+	// Trade and income export, amounts are converted to the quote currency
+	ExportQuoteCurrency string
+
+	// Admin API and runtime state
+	AdminToken       string
+	StateDir         string
+	TradingStateFile string // legacy pause file, imported once into the state store
+	StateBackend     string
+	JournalDir       string
+	AuditDir         string
+	AuditMaxFileSize int64         // bytes, the audit log rotates to a new file past it
+	StateTTL         time.Duration // symbol state untouched for longer is evicted
 }
 
 type OrderBook struct {
-	Side      string
-	TimeStamp time.Time
+	Side         string
+	TimeStamp    time.Time   // last entry
+	Entries      []time.Time // entries inside the rolling window
+	LockoutUntil time.Time   // no entries until, after a stop-out
+	Adds         int         // add-ons to the open position
+	LastPrice    float64     // price of the last entry or add-on, the add distance is measured from it
+	Strategy     string      // strategy of the last entry
+}
+
+// Streak counts the consecutive wins and losses of a strategy or a symbol
+type Streak struct {
+	Wins       int
+	Losses     int
+	Multiplier float64
+}
+
+// Workflow is the intent log record of one entry, it's kept until every
+// planned step is done so a restart can resume or compensate it
+type Workflow struct {
+	ID           string // correlation id of the alert
+	Symbol       string
+	PositionSide string
+	CloseSide    string
+	TakeProfit   bool // planned steps
+	StopLoss     bool
+	TpSlMode     string
+	Step         string // last completed step
+	StartedAt    time.Time
+	UpdatedAt    time.Time
 }
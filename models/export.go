@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// ExportRequest selects the account history to export, amounts are converted
+// to Quote and the days are cut in Location
+type ExportRequest struct {
+	From     time.Time
+	To       time.Time
+	Symbols  []string // empty exports every symbol traded in the range
+	Location *time.Location
+	Quote    string
+}
+
+// ExportTrade is one account trade with the income booked for it
+type ExportTrade struct {
+	Time            time.Time `json:"time"`
+	Symbol          string    `json:"symbol"`
+	TradeID         int64     `json:"trade_id"`
+	OrderID         int64     `json:"order_id"`
+	Side            string    `json:"side"`
+	PositionSide    string    `json:"position_side"`
+	Maker           bool      `json:"maker"`
+	Price           float64   `json:"price"`
+	Quantity        float64   `json:"quantity"`
+	QuoteQuantity   float64   `json:"quote_quantity"`
+	RealizedPnl     float64   `json:"realized_pnl"`
+	Commission      float64   `json:"commission"`
+	CommissionAsset string    `json:"commission_asset"`
+	CommissionQuote float64   `json:"commission_quote"` // commission converted to the quote currency
+	Net             float64   `json:"net"`              // realized PnL minus commission, in the quote currency
+}
+
+// ExportDay sums the trades and the income of one day in the export time zone
+type ExportDay struct {
+	Date        string  `json:"date"`
+	Trades      int     `json:"trades"`
+	Volume      float64 `json:"volume"`
+	RealizedPnl float64 `json:"realized_pnl"`
+	Commission  float64 `json:"commission"`
+	FundingFee  float64 `json:"funding_fee"`
+	OtherIncome float64 `json:"other_income"` // transfers excluded, e.g. insurance clear or rebates
+	Net         float64 `json:"net"`
+}
+
+// Export is the merged trade and income history of a date range
+type Export struct {
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	TimeZone string        `json:"time_zone"`
+	Quote    string        `json:"quote"`
+	Trades   []ExportTrade `json:"trades"`
+	Days     []ExportDay   `json:"days"`
+	Warnings []string      `json:"warnings,omitempty"` // parts of the range Binance no longer has
+}
@@ -0,0 +1,20 @@
+package models
+
+// SymbolGroup is a set of correlated symbols sharing exposure limits, 0 means unlimited
+type SymbolGroup struct {
+	Name              string   `json:"name"`
+	Symbols           []string `json:"symbols"`
+	MaxPositions      int      `json:"max_positions"`
+	MaxLongPositions  int      `json:"max_long_positions"`
+	MaxShortPositions int      `json:"max_short_positions"`
+	MaxNotional       float64  `json:"max_notional"`
+}
+
+func (g SymbolGroup) Contains(symbol string) bool {
+	for _, v := range g.Symbols {
+		if v == symbol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// JournalEntry is one step of an alert, the alert itself, the pre-trade
+// decision, the orders and the fills are linked by CorrelationID
+type JournalEntry struct {
+	Time          time.Time   `json:"time"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Type          string      `json:"type"` // alert, decision, order or fill
+	Symbol        string      `json:"symbol,omitempty"`
+	Side          string      `json:"side,omitempty"`
+	Strategy      string      `json:"strategy,omitempty"`
+	Outcome       string      `json:"outcome,omitempty"`
+	Reason        string      `json:"reason,omitempty"`
+	SourceIP      string      `json:"source_ip,omitempty"`
+	RealizedPnl   float64     `json:"realized_pnl,omitempty"`
+	Data          interface{} `json:"data,omitempty"` // raw body and command, order request and response, or fill
+}
+
+// JournalFilter selects the correlations with at least one entry matching
+// every set field, empty fields match anything
+type JournalFilter struct {
+	CorrelationID string
+	Symbol        string
+	Strategy      string
+	Outcome       string
+	Type          string
+	From          time.Time
+	To            time.Time
+	Limit         int // latest entries, 0 means no limit
+}
@@ -1,8 +1,16 @@
 package models
 
+import "time"
+
 type CalculateRealizedPnl struct {
 	Profit     float64
 	Loss       float64
 	Commission float64
 	NetProfit  float64
 }
+
+type LatencyReport struct {
+	Count   int
+	Average time.Duration
+	Max     time.Duration
+}
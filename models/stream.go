@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// StreamHealth is the state of the user data stream
+type StreamHealth struct {
+	Connected          bool      `json:"connected"`
+	ConnectedSince     time.Time `json:"connected_since"`
+	LastEventAt        time.Time `json:"last_event_at"`
+	ListenKeyCreatedAt time.Time `json:"listen_key_created_at"`
+	LastKeepaliveAt    time.Time `json:"last_keepalive_at"`
+	Reconnects         int       `json:"reconnects"`
+	ConsecutiveErrors  int       `json:"consecutive_errors"`
+	LastError          string    `json:"last_error,omitempty"`
+	LastErrorAt        time.Time `json:"last_error_at"`
+	LastBackfillAt     time.Time `json:"last_backfill_at"`
+	BackfilledFills    int       `json:"backfilled_fills"` // since the start
+}
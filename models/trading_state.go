@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"tradingview-binance-webhook/constants"
+)
+
+// TradingState is the pause switch of the bot or of one symbol
+type TradingState struct {
+	State     string    `json:"state"`
+	Until     time.Time `json:"until,omitempty"` // zero never expires
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by"`
+}
+
+// IsExpired returns true when the state had an expiry and it has passed
+func (t TradingState) IsExpired(now time.Time) bool {
+	return !t.Until.IsZero() && !now.Before(t.Until)
+}
+
+// Effective returns the state, or running when it expired
+func (t TradingState) Effective(now time.Time) string {
+	if t.State == "" || t.IsExpired(now) {
+		return constants.TradingStateRunning
+	}
+	return t.State
+}
+
+type TradingStates struct {
+	Global  TradingState            `json:"global"`
+	Symbols map[string]TradingState `json:"symbols"`
+}
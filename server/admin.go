@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+
+	"tradingview-binance-webhook/audit"
+	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/export"
+	"tradingview-binance-webhook/future"
+	"tradingview-binance-webhook/journal"
+)
+
+type adminHandler struct {
+	s        future.Service
+	journal  journal.Service
+	audit    audit.Service
+	export   export.Service
+	location *time.Location
+	quote    string
+	token    string
+}
+
+func (h *adminHandler) router() chi.Router {
+	r := chi.NewRouter()
+	r.Use(h.auditRequest)
+	r.Use(h.authenticate)
+
+	r.Get("/trading-state", h.getTradingState)
+	r.Put("/trading-state", h.setTradingState)
+	r.Get("/journal", h.queryJournal)
+	r.Get("/export", h.exportHistory)
+	r.Get("/stream-health", h.getStreamHealth)
+	r.Get("/account", h.getAccount)
+
+	return r
+}
+
+// auditRequest records every admin API call, rejected ones included, the
+// token itself is never recorded
+func (h *adminHandler) auditRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep a copy of the body for the audit log
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		h.audit.Record("admin "+r.RemoteAddr, constants.AuditActionAdmin, map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.RequestURI(),
+			"body":   string(body),
+			"status": ww.Status(),
+		})
+	})
+}
+
+// authenticate requires "Authorization: Bearer ADMIN_TOKEN", the admin API is
+// disabled when no token is configured
+func (h *adminHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			render.Render(w, r, ErrUnauthorized(errors.New("unauthorized")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type tradingStateRequest struct {
+	Symbol    string `json:"symbol"`     // empty for the global state
+	State     string `json:"state"`      // running, entries_paused or paused
+	ExpiresIn string `json:"expires_in"` // optional, e.g. 30m or 2h
+}
+
+func (o *tradingStateRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+func (h *adminHandler) getTradingState(w http.ResponseWriter, r *http.Request) {
+	render.Respond(w, r, SuccessResponse(h.s.GetTradingStates(), "success"))
+}
+
+func (h *adminHandler) setTradingState(w http.ResponseWriter, r *http.Request) {
+	req := &tradingStateRequest{}
+	if err := render.Bind(r, req); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	var until time.Time
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	if err := h.s.SetTradingState(strings.ToUpper(req.Symbol), req.State, until, "admin "+r.RemoteAddr); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Respond(w, r, SuccessResponse(h.s.GetTradingStates(), "success"))
+}
+
+func (h *adminHandler) getStreamHealth(w http.ResponseWriter, r *http.Request) {
+	render.Respond(w, r, SuccessResponse(h.s.StreamHealth(), "success"))
+}
+
+func (h *adminHandler) getAccount(w http.ResponseWriter, r *http.Request) {
+	render.Respond(w, r, SuccessResponse(h.s.AccountSnapshot(), "success"))
+}
+
+// queryJournal returns the journal entries of the alerts matching the query
+// parameters id, symbol, strategy, outcome, type, from, to and limit
+func (h *adminHandler) queryJournal(w http.ResponseWriter, r *http.Request) {
+	filter, err := journal.ParseFilter(r.URL.Query(), h.location)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	entries, err := h.journal.Query(filter)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	render.Respond(w, r, SuccessResponse(entries, "success"))
+}
+
+// exportHistory returns the trades and income of the range given by the
+// query parameters from, to, symbol, tz and quote. format=csv returns the
+// table of view (trades or days) as a file, JSON holds both
+func (h *adminHandler) exportHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	request, err := export.ParseRequest(query, h.location, h.quote)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = export.FormatJSON
+	}
+	view := query.Get("view")
+	if view == "" {
+		view = export.ViewTrades
+	}
+	if format != export.FormatJSON && format != export.FormatCSV {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid format: %s", format)))
+		return
+	}
+	if view != export.ViewTrades && view != export.ViewDays {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid view: %s", view)))
+		return
+	}
+
+	result, err := h.export.Export(request)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if format == export.FormatJSON {
+		render.Respond(w, r, SuccessResponse(result, "success"))
+		return
+	}
+
+	filename := fmt.Sprintf("%s_%s_%s.csv", view, request.From.In(request.Location).Format("20060102"), request.To.In(request.Location).Format("20060102"))
+	for _, warning := range result.Warnings {
+		w.Header().Add("X-Export-Warning", warning)
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	export.Write(w, result, format, view)
+}
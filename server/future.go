@@ -9,17 +9,23 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 
+	"tradingview-binance-webhook/audit"
+	"tradingview-binance-webhook/constants"
 	"tradingview-binance-webhook/future"
+	"tradingview-binance-webhook/journal"
 	"tradingview-binance-webhook/models"
 )
 
 type futureHandler struct {
-	s future.Service
+	s       future.Service
+	journal journal.Service
+	audit   audit.Service
 }
 
 func (h *futureHandler) router() chi.Router {
@@ -35,6 +41,7 @@ func (h *futureHandler) receiveCommandFromTradingView(w http.ResponseWriter, r *
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	receivedAt := time.Now()
 
 	reqBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -44,41 +51,87 @@ func (h *futureHandler) receiveCommandFromTradingView(w http.ResponseWriter, r *
 	}
 
 	strReqBody := string(reqBody)
+	alert := models.JournalEntry{
+		Time:          receivedAt,
+		CorrelationID: journal.NewCorrelationID(),
+		Type:          constants.JournalTypeAlert,
+		SourceIP:      r.RemoteAddr,
+		Outcome:       constants.JournalOutcomeAccepted,
+	}
+	// The alert is journaled as soon as it's parsed, the outcome of the entry
+	// is the decision entry that follows it
+	outcome, reason := constants.JournalOutcomeAccepted, ""
+	defer func() {
+		h.audit.Record("alert "+alert.SourceIP, constants.AuditActionAlert, map[string]interface{}{
+			"correlation_id": alert.CorrelationID,
+			"body":           strReqBody,
+			"outcome":        outcome,
+			"reason":         reason,
+		})
+	}()
+
 	// Parde Command
 	command, err := parseRawCommand(strReqBody)
 	if err != nil {
 		log.Println(err)
+		outcome, reason = constants.JournalOutcomeRejected, err.Error()
+		alert.Outcome, alert.Reason = outcome, reason
+		alert.Data = map[string]interface{}{"body": strReqBody}
+		h.journal.Record(alert)
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	command.ReceivedAt = receivedAt
+	command.CorrelationID = alert.CorrelationID
+	alert.Symbol, alert.Side, alert.Strategy = command.Symbol, string(command.Side), command.Strategy
+	alert.Data = map[string]interface{}{"body": strReqBody, "command": command}
+	h.journal.Record(alert)
+
+	// reject journals a rejection decided here, the service journals its own
+	reject := func(err error) {
+		h.journal.Record(models.JournalEntry{
+			CorrelationID: command.CorrelationID,
+			Type:          constants.JournalTypeDecision,
+			Symbol:        command.Symbol,
+			Side:          string(command.Side),
+			Strategy:      command.Strategy,
+			Outcome:       constants.JournalOutcomeRejected,
+			Reason:        err.Error(),
+		})
+	}
 
 	log.Printf("Command: %s\n Symbol: %s, Side: %s, Amount: %d, TP: %t, SL: %t, CheckWL: %t\n", strReqBody, command.Symbol, command.Side, command.AmountUSD, command.IsTP, command.IsSL, command.IsCheckWL)
 
+	// Trading state
+	if state := h.s.TradingState(command.Symbol); state != constants.TradingStateRunning {
+		err := fmt.Errorf("trading is %s for %s", state, command.Symbol)
+		log.Println(err)
+		outcome, reason = constants.JournalOutcomeRejected, err.Error()
+		reject(err)
+		render.Render(w, r, ErrPaused(err))
+		return
+	}
+
 	// Side
+	var result *models.CommandResult
 	switch command.Side {
 	case futures.PositionSideTypeLong: // Long
-		err := h.s.Long(command)
-		if err != nil {
-			log.Println(err)
-			render.Render(w, r, ErrInvalidRequest(err))
-			return
-		}
-
-		render.Respond(w, r, SuccessResponse(nil, "success"))
+		result, err = h.s.Long(command)
 	case futures.PositionSideTypeShort: // Short
-		err := h.s.Short(command)
-		if err != nil {
-			log.Println(err)
-			render.Render(w, r, ErrInvalidRequest(err))
-			return
-		}
-
-		render.Respond(w, r, SuccessResponse(nil, "success"))
+		result, err = h.s.Short(command)
 	default:
 		fmt.Printf("%s.\n", command.Side)
+		err = fmt.Errorf("invalid side: %s", command.Side)
+		reject(err)
+	}
+	if err != nil {
+		log.Println(err)
+		outcome, reason = constants.JournalOutcomeRejected, err.Error()
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
 
-	render.Respond(w, r, SuccessResponse(nil, "success"))
+	render.Respond(w, r, SuccessResponse(result, "success"))
 }
 
 func parseRawCommand(rawCommand string) (*models.Command, error) {
@@ -119,5 +172,61 @@ func parseRawCommand(rawCommand string) (*models.Command, error) {
 	intAmountUSD, _ := strconv.Atoi(arr[2])
 	c.AmountUSD = int64(intAmountUSD)
 
+	// Options: key=value fields after the positional ones, e.g. p={{close}}
+	for _, v := range arr[3:] {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			continue
+		}
+		if err := parseCommandOption(c, strings.ToLower(key), value); err != nil {
+			return nil, err
+		}
+	}
+
 	return c, nil
 }
+
+func parseCommandOption(c *models.Command, key, value string) error {
+	switch key {
+	case "p", "price": // Signal price
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid price option: %s", value)
+		}
+		c.SignalPrice = f
+	case "t", "time": // Alert time
+		t, err := parseAlertTime(value)
+		if err != nil {
+			return fmt.Errorf("invalid time option: %s", value)
+		}
+		c.AlertTime = t
+	case "tpsl": // TP/SL mode
+		value = strings.ToLower(value)
+		if value != constants.TpSlModePercent && value != constants.TpSlModeATR {
+			return fmt.Errorf("invalid tpsl option: %s", value)
+		}
+		c.TpSlMode = value
+	case "risk": // Risk per trade in percent of equity
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 {
+			return fmt.Errorf("invalid risk option: %s", value)
+		}
+		c.RiskPercentage = f
+	case "s", "strategy": // Strategy name
+		c.Strategy = value
+	case "id": // Alert id
+		c.AlertID = value
+	}
+	return nil
+}
+
+// parseAlertTime accepts {{timenow}} (RFC3339) or a unix timestamp in seconds or milliseconds
+func parseAlertTime(value string) (time.Time, error) {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if i > 1e12 {
+			return time.UnixMilli(i), nil
+		}
+		return time.Unix(i, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAlertTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"RFC3339", "2022-08-01T13:25:00Z", time.Date(2022, 8, 1, 13, 25, 0, 0, time.UTC), false},
+		{"RFC3339 with offset", "2022-08-01T20:25:00+07:00", time.Date(2022, 8, 1, 13, 25, 0, 0, time.UTC), false},
+		{"unix seconds", "1659360300", time.Date(2022, 8, 1, 13, 25, 0, 0, time.UTC), false},
+		{"unix milliseconds", "1659360300123", time.Date(2022, 8, 1, 13, 25, 0, 123e6, time.UTC), false},
+		{"date only", "2022-08-01", time.Time{}, true},
+		{"empty", "", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAlertTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAlertTime(%q) error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("parseAlertTime(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
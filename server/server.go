@@ -4,29 +4,51 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 
+	"tradingview-binance-webhook/audit"
 	"tradingview-binance-webhook/constants"
+	"tradingview-binance-webhook/export"
 	"tradingview-binance-webhook/future"
+	"tradingview-binance-webhook/journal"
 )
 
 type Server struct {
-	router    chi.Router
-	client    *futures.Client
-	futureSvc future.Service
+	router     chi.Router
+	client     *futures.Client
+	futureSvc  future.Service
+	journal    journal.Service
+	audit      audit.Service
+	export     export.Service
+	location   *time.Location
+	quote      string
+	adminToken string
 }
 
 func New(
 	client *futures.Client,
 	futureSvc future.Service,
+	journalService journal.Service,
+	auditService audit.Service,
+	exportService export.Service,
+	location *time.Location,
+	quote string,
+	adminToken string,
 ) *Server {
 	s := &Server{
-		client:    client,
-		futureSvc: futureSvc,
+		client:     client,
+		futureSvc:  futureSvc,
+		journal:    journalService,
+		audit:      auditService,
+		export:     exportService,
+		location:   location,
+		quote:      quote,
+		adminToken: adminToken,
 	}
 
 	// Routers
@@ -36,8 +58,11 @@ func New(
 	r.Group(func(r chi.Router) {
 
 		r.Route("/v1", func(r chi.Router) {
-			futureSvcSvc := futureHandler{s.futureSvc}
+			futureSvcSvc := futureHandler{s.futureSvc, s.journal, s.audit}
 			r.Mount("/", futureSvcSvc.router())
+
+			adminSvc := adminHandler{s.futureSvc, s.journal, s.audit, s.export, s.location, s.quote, s.adminToken}
+			r.Mount("/admin", adminSvc.router())
 		})
 	})
 
@@ -88,6 +113,25 @@ func ErrInvalidRequest(err error) render.Renderer {
 	}
 }
 
+func ErrUnauthorized(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusUnauthorized,
+		AppCode:        constants.CodeUnauthorized,
+		Message:        err.Error(),
+	}
+}
+
+func ErrPaused(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusLocked,
+		AppCode:        constants.CodePaused,
+		StatusText:     "paused",
+		Message:        err.Error(),
+	}
+}
+
 type ApiResponse struct {
 	HTTPStatusCode int `json:"-"` // http response status code
 
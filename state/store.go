@@ -0,0 +1,39 @@
+package state
+
+import "tradingview-binance-webhook/models"
+
+// Store keeps the bot state. Every method is safe for concurrent use, Lock
+// additionally serializes whole workflows of one symbol.
+type Store interface {
+	// Lock blocks until no other caller holds symbol, the returned func releases it
+	Lock(symbol string) func()
+
+	// GetOrderBook returns a copy of the order book of key
+	GetOrderBook(key string) (models.OrderBook, bool)
+	// UpdateOrderBook creates the order book of key when missing and lets fn modify it atomically
+	UpdateOrderBook(key string, fn func(orderBook *models.OrderBook)) error
+	DeleteOrderBook(key string) error
+
+	// GetTradingStates returns the saved pause switches, false when none were saved yet
+	GetTradingStates() (*models.TradingStates, bool)
+	SaveTradingStates(states *models.TradingStates) error
+
+	GetStreak(key string) (models.Streak, bool)
+	SaveStreak(key string, streak models.Streak) error
+
+	// MarkAlertProcessed records the alert id and returns true when it was already processed
+	MarkAlertProcessed(id string) (bool, error)
+	// UnmarkAlertProcessed forgets the alert id, so a resend of an alert that
+	// made no entry is accepted
+	UnmarkAlertProcessed(id string) error
+
+	// Workflows are the intent log of the multi-step entries
+	SaveWorkflow(workflow models.Workflow) error
+	DeleteWorkflow(id string) error
+	ListWorkflows() ([]models.Workflow, error)
+
+	// EvictExpired drops the entries untouched for longer than the TTL and returns how many
+	EvictExpired() int
+
+	Close() error
+}
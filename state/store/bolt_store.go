@@ -0,0 +1,287 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/state"
+)
+
+// schemaVersion is bumped with a migration whenever the stored layout changes
+const schemaVersion = 1
+
+var (
+	bucketMeta          = []byte("meta")
+	bucketOrderBooks    = []byte("order_books")
+	bucketTradingStates = []byte("trading_states")
+	bucketStreaks       = []byte("streaks")
+	bucketAlerts        = []byte("alerts")
+	bucketWorkflows     = []byte("workflows")
+
+	keySchemaVersion = []byte("schema_version")
+	keyTradingStates = []byte("states")
+)
+
+// migrations upgrade the database from version i+1 to i+2
+var migrations = []func(tx *bolt.Tx) error{}
+
+type storedOrderBook struct {
+	OrderBook models.OrderBook `json:"order_book"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+type boltStore struct {
+	*symbolLocks
+
+	db  *bolt.DB
+	ttl time.Duration
+}
+
+// NewBoltStore keeps the state in an embedded bbolt database at path, the
+// schema is created or migrated before it returns
+func NewBoltStore(path string, ttl time.Duration) (state.Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(migrate); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{
+		symbolLocks: newSymbolLocks(),
+		db:          db,
+		ttl:         ttl,
+	}, nil
+}
+
+// migrate creates the buckets and upgrades the schema to schemaVersion
+func migrate(tx *bolt.Tx) error {
+	for _, name := range [][]byte{bucketMeta, bucketOrderBooks, bucketTradingStates, bucketStreaks, bucketAlerts, bucketWorkflows} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	meta := tx.Bucket(bucketMeta)
+	version := schemaVersion
+	if v := meta.Get(keySchemaVersion); v != nil {
+		i, err := strconv.Atoi(string(v))
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q", v)
+		}
+		version = i
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("state schema version %d is newer than supported %d", version, schemaVersion)
+	}
+
+	for ; version < schemaVersion; version++ {
+		log.Printf("Migrating state schema to version %d\n", version+1)
+		if err := migrations[version-1](tx); err != nil {
+			return fmt.Errorf("migrate state schema to version %d: %v", version+1, err)
+		}
+	}
+
+	return meta.Put(keySchemaVersion, []byte(strconv.Itoa(schemaVersion)))
+}
+
+func (b *boltStore) Lock(symbol string) func() {
+	return b.lock(symbol)
+}
+
+func (b *boltStore) get(bucket, key []byte, v interface{}) bool {
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get(key)
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, v)
+	})
+	if err != nil {
+		log.Printf("State %s/%s: %v\n", bucket, key, err)
+		return false
+	}
+	return found
+}
+
+func put(tx *bolt.Tx, bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(key, data)
+}
+
+func (b *boltStore) GetOrderBook(key string) (models.OrderBook, bool) {
+	var stored storedOrderBook
+	ok := b.get(bucketOrderBooks, []byte(key), &stored)
+	return stored.OrderBook, ok
+}
+
+func (b *boltStore) UpdateOrderBook(key string, fn func(orderBook *models.OrderBook)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var stored storedOrderBook
+		if data := tx.Bucket(bucketOrderBooks).Get([]byte(key)); data != nil {
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+		}
+		fn(&stored.OrderBook)
+		stored.UpdatedAt = time.Now()
+		return put(tx, bucketOrderBooks, []byte(key), stored)
+	})
+}
+
+func (b *boltStore) DeleteOrderBook(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketOrderBooks).Delete([]byte(key))
+	})
+}
+
+func (b *boltStore) GetTradingStates() (*models.TradingStates, bool) {
+	states := &models.TradingStates{}
+	if !b.get(bucketTradingStates, keyTradingStates, states) {
+		return nil, false
+	}
+	if states.Symbols == nil {
+		states.Symbols = make(map[string]models.TradingState)
+	}
+	return states, true
+}
+
+func (b *boltStore) SaveTradingStates(states *models.TradingStates) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketTradingStates, keyTradingStates, states)
+	})
+}
+
+func (b *boltStore) GetStreak(key string) (models.Streak, bool) {
+	var streak models.Streak
+	ok := b.get(bucketStreaks, []byte(key), &streak)
+	return streak, ok
+}
+
+func (b *boltStore) SaveStreak(key string, streak models.Streak) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketStreaks, []byte(key), streak)
+	})
+}
+
+func (b *boltStore) MarkAlertProcessed(id string) (bool, error) {
+	var processed bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		alerts := tx.Bucket(bucketAlerts)
+		if alerts.Get([]byte(id)) != nil {
+			processed = true
+			return nil
+		}
+		return put(tx, bucketAlerts, []byte(id), time.Now())
+	})
+	return processed, err
+}
+
+func (b *boltStore) UnmarkAlertProcessed(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAlerts).Delete([]byte(id))
+	})
+}
+
+func (b *boltStore) SaveWorkflow(workflow models.Workflow) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketWorkflows, []byte(workflow.ID), workflow)
+	})
+}
+
+func (b *boltStore) DeleteWorkflow(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWorkflows).Delete([]byte(id))
+	})
+}
+
+func (b *boltStore) ListWorkflows() ([]models.Workflow, error) {
+	var workflows []models.Workflow
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWorkflows).ForEach(func(k, v []byte) error {
+			var workflow models.Workflow
+			if err := json.Unmarshal(v, &workflow); err != nil {
+				return err
+			}
+			workflows = append(workflows, workflow)
+			return nil
+		})
+	})
+	return workflows, err
+}
+
+func (b *boltStore) EvictExpired() int {
+	if b.ttl <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	var evicted int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := tx.Bucket(bucketOrderBooks).ForEach(func(k, v []byte) error {
+			var stored storedOrderBook
+			if err := json.Unmarshal(v, &stored); err != nil || isOrderBookExpired(stored.OrderBook, stored.UpdatedAt, b.ttl, now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := tx.Bucket(bucketOrderBooks).Delete(k); err != nil {
+				return err
+			}
+		}
+		evicted += len(expired)
+
+		expired = nil
+		err = tx.Bucket(bucketAlerts).ForEach(func(k, v []byte) error {
+			var processedAt time.Time
+			if err := json.Unmarshal(v, &processedAt); err != nil || now.Sub(processedAt) > b.ttl {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := tx.Bucket(bucketAlerts).Delete(k); err != nil {
+				return err
+			}
+		}
+		evicted += len(expired)
+		return nil
+	})
+	if err != nil {
+		log.Println("EvictExpired: ", err)
+		return 0
+	}
+	return evicted
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,27 @@
+package store
+
+import "sync"
+
+// symbolLocks hands out one mutex per symbol, the set of symbols is small so
+// they're never dropped
+type symbolLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newSymbolLocks() *symbolLocks {
+	return &symbolLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+func (l *symbolLocks) lock(symbol string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[symbol]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[symbol] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
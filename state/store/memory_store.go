@@ -0,0 +1,207 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"tradingview-binance-webhook/models"
+	"tradingview-binance-webhook/state"
+)
+
+type orderBookEntry struct {
+	orderBook models.OrderBook
+	updatedAt time.Time
+}
+
+type memoryStore struct {
+	*symbolLocks
+
+	mu            sync.Mutex
+	ttl           time.Duration
+	orderBooks    map[string]*orderBookEntry
+	tradingStates *models.TradingStates
+	streaks       map[string]models.Streak
+	alerts        map[string]time.Time
+	workflows     map[string]models.Workflow
+}
+
+// NewMemoryStore keeps the state in memory, entries untouched for ttl are
+// evicted, 0 keeps them forever
+func NewMemoryStore(ttl time.Duration) state.Store {
+	return &memoryStore{
+		symbolLocks: newSymbolLocks(),
+		ttl:         ttl,
+		orderBooks:  make(map[string]*orderBookEntry),
+		streaks:     make(map[string]models.Streak),
+		alerts:      make(map[string]time.Time),
+		workflows:   make(map[string]models.Workflow),
+	}
+}
+
+func (m *memoryStore) Lock(symbol string) func() {
+	return m.lock(symbol)
+}
+
+func (m *memoryStore) GetOrderBook(key string) (models.OrderBook, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.orderBooks[key]
+	if !ok {
+		return models.OrderBook{}, false
+	}
+	return copyOrderBook(entry.orderBook), true
+}
+
+func (m *memoryStore) UpdateOrderBook(key string, fn func(orderBook *models.OrderBook)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.orderBooks[key]
+	if !ok {
+		entry = &orderBookEntry{}
+		m.orderBooks[key] = entry
+	}
+	fn(&entry.orderBook)
+	entry.updatedAt = time.Now()
+	return nil
+}
+
+func (m *memoryStore) DeleteOrderBook(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.orderBooks, key)
+	return nil
+}
+
+func (m *memoryStore) GetTradingStates() (*models.TradingStates, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tradingStates == nil {
+		return nil, false
+	}
+	return copyTradingStates(m.tradingStates), true
+}
+
+func (m *memoryStore) SaveTradingStates(states *models.TradingStates) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tradingStates = copyTradingStates(states)
+	return nil
+}
+
+func (m *memoryStore) GetStreak(key string) (models.Streak, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	streak, ok := m.streaks[key]
+	return streak, ok
+}
+
+func (m *memoryStore) SaveStreak(key string, streak models.Streak) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.streaks[key] = streak
+	return nil
+}
+
+func (m *memoryStore) MarkAlertProcessed(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.alerts[id]; ok {
+		return true, nil
+	}
+	m.alerts[id] = time.Now()
+	return false, nil
+}
+
+func (m *memoryStore) UnmarkAlertProcessed(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.alerts, id)
+	return nil
+}
+
+func (m *memoryStore) SaveWorkflow(workflow models.Workflow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.workflows[workflow.ID] = workflow
+	return nil
+}
+
+func (m *memoryStore) DeleteWorkflow(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.workflows, id)
+	return nil
+}
+
+func (m *memoryStore) ListWorkflows() ([]models.Workflow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var workflows []models.Workflow
+	for _, w := range m.workflows {
+		workflows = append(workflows, w)
+	}
+	return workflows, nil
+}
+
+func (m *memoryStore) EvictExpired() int {
+	if m.ttl <= 0 {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var evicted int
+	for key, entry := range m.orderBooks {
+		if isOrderBookExpired(entry.orderBook, entry.updatedAt, m.ttl, now) {
+			delete(m.orderBooks, key)
+			evicted++
+		}
+	}
+	for id, processedAt := range m.alerts {
+		if now.Sub(processedAt) > m.ttl {
+			delete(m.alerts, id)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// isOrderBookExpired returns true when the order book was untouched for ttl
+// and its stop-out lockout is over
+func isOrderBookExpired(orderBook models.OrderBook, updatedAt time.Time, ttl time.Duration, now time.Time) bool {
+	return now.Sub(updatedAt) > ttl && now.After(orderBook.LockoutUntil)
+}
+
+func copyOrderBook(orderBook models.OrderBook) models.OrderBook {
+	orderBook.Entries = append([]time.Time(nil), orderBook.Entries...)
+	return orderBook
+}
+
+func copyTradingStates(states *models.TradingStates) *models.TradingStates {
+	result := &models.TradingStates{
+		Global:  states.Global,
+		Symbols: make(map[string]models.TradingState, len(states.Symbols)),
+	}
+	for k, v := range states.Symbols {
+		result.Symbols[k] = v
+	}
+	return result
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,3 +26,81 @@ func EpochEndDate(t time.Time) time.Time {
 	year, month, day := t.Date()
 	return time.Date(year, month, day, 23, 59, 59, 59, t.Location())
 }
+
+// FormatFloat rounds num to precision and formats it without exponent
+func FormatFloat(num float64, precision int) string {
+	return strconv.FormatFloat(ToFixed(num, precision), 'f', precision, 64)
+}
+
+// ParseFloatMap parses "KEY:VALUE,KEY:VALUE" into a map, invalid pairs are skipped
+func ParseFloatMap(s string) map[string]float64 {
+	result := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+			result[strings.TrimSpace(kv[0])] = f
+		}
+	}
+	return result
+}
+
+// TradingDayStart returns the start of the trading day containing t, a day
+// starts at resetTime ("15:04") in loc
+func TradingDayStart(t time.Time, loc *time.Location, resetTime string) time.Time {
+	var hour, min int
+	if r, err := time.Parse("15:04", resetTime); err == nil {
+		hour, min = r.Hour(), r.Minute()
+	}
+
+	t = t.In(loc)
+	year, month, day := t.Date()
+	start := time.Date(year, month, day, hour, min, 0, 0, loc)
+	if t.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// Floor rounds num down to precision
+func Floor(num float64, precision int) float64 {
+	output := math.Pow(10, float64(precision))
+	return math.Floor(num*output) / output
+}
+
+// ParseStringMap parses "KEY:VALUE,KEY:VALUE" into a map, invalid pairs are skipped
+func ParseStringMap(s string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result
+}
+
+// ParseDurationMap parses "KEY:SECONDS,KEY:SECONDS" into a map of durations
+func ParseDurationMap(s string) map[string]time.Duration {
+	result := make(map[string]time.Duration)
+	for k, v := range ParseFloatMap(s) {
+		result[k] = time.Duration(v * float64(time.Second))
+	}
+	return result
+}
+
+// ParseTime accepts RFC3339, "2006-01-02 15:04" or a date in loc, isDate
+// tells a bare date so an end of range can include the whole day
+func ParseTime(value string, loc *time.Location) (t time.Time, isDate bool, err error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, loc); err == nil {
+		return t, false, nil
+	}
+	t, err = time.ParseInLocation("2006-01-02", value, loc)
+	return t, true, err
+}